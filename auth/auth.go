@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Key API key and the identity of the client using it.
+type Key struct {
+	Client string
+	Key    string
+}
+
+// Authenticator Authenticate the HTTP requests by bearer token or API key.
+// The keys come from the config, read on every request so config reloads apply, and from a keys file reloaded on change.
+type Authenticator struct {
+	configKeys func() []Key
+	keysFile   string
+	mu         sync.RWMutex
+	fileKeys   []Key
+	watcher    *fsnotify.Watcher
+}
+
+type clientKey struct{}
+
+// NewAuthenticator Create an authenticator, keysFile is optional.
+func NewAuthenticator(configKeys func() []Key, keysFile string) (*Authenticator, error) {
+	a := &Authenticator{
+		configKeys: configKeys,
+		keysFile:   keysFile,
+	}
+	if keysFile == "" {
+		return a, nil
+	}
+
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch keys file: %w", err)
+	}
+	// Watch the directory, editors and secret mounts replace the file instead of writing it.
+	if err := watcher.Add(filepath.Dir(keysFile)); err != nil {
+		_ = watcher.Close()
+		return nil, fmt.Errorf("failed to watch keys file: %w", err)
+	}
+	a.watcher = watcher
+	go a.watch()
+	return a, nil
+}
+
+// Close Stop watching the keys file.
+func (a *Authenticator) Close() error {
+	if a.watcher == nil {
+		return nil
+	}
+	return a.watcher.Close()
+}
+
+// Authenticate Return the client of the API key.
+func (a *Authenticator) Authenticate(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	a.mu.RLock()
+	fileKeys := a.fileKeys
+	a.mu.RUnlock()
+
+	// Compare every key in constant time so the response time does not leak a matching prefix.
+	client, found := "", false
+	for _, keys := range [][]Key{a.configKeys(), fileKeys} {
+		for _, key := range keys {
+			if subtle.ConstantTimeCompare([]byte(key.Key), []byte(token)) == 1 && !found {
+				client, found = key.Client, true
+			}
+		}
+	}
+	return client, found
+}
+
+// Middleware Reject the requests without a valid API key, the client of the key is stored in the request context.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := a.Authenticate(requestToken(r))
+		if !ok {
+			sandbox.InternalLogger.Warnf("Rejected unauthenticated request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="code-sandbox-mcp"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+	})
+}
+
+// ClientFromContext Return the authenticated client of the request.
+func ClientFromContext(ctx context.Context) (string, bool) {
+	client, ok := ctx.Value(clientKey{}).(string)
+	return client, ok
+}
+
+// requestToken Return the API key of the request, from the Authorization bearer token or the X-API-Key header.
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// watch Reload the keys file when it changes, the previous keys are kept if it is invalid.
+func (a *Authenticator) watch() {
+	for {
+		select {
+		case event, ok := <-a.watcher.Events:
+			if !ok {
+				return
+			}
+			// Secret mounts swap a symlink of the directory, so any change of the directory reloads the file.
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if err := a.reload(); err != nil {
+				sandbox.InternalLogger.Errorf("Failed to reload keys file, keeping the previous keys: %v", err)
+				continue
+			}
+			sandbox.InternalLogger.Infof("Reloaded keys file %s", a.keysFile)
+		case err, ok := <-a.watcher.Errors:
+			if !ok {
+				return
+			}
+			sandbox.InternalLogger.Errorf("Failed to watch keys file: %v", err)
+		}
+	}
+}
+
+// reload Read the keys file.
+func (a *Authenticator) reload() error {
+	keys, err := readKeysFile(a.keysFile)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.fileKeys = keys
+	a.mu.Unlock()
+	return nil
+}
+
+// readKeysFile Read a keys file, made of "<client> <key>" lines, blank lines and lines starting with # are ignored.
+func readKeysFile(path string) ([]Key, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keys file: %w", err)
+	}
+	defer file.Close()
+
+	var keys []Key
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid keys file %s line %d: expected \"<client> <key>\"", path, line)
+		}
+		keys = append(keys, Key{Client: fields[0], Key: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read keys file: %w", err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox/docker"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runBuildImages Build the derived images of every language declaring prebuilt packages.
+func runBuildImages(args []string) int {
+	var config configFlags
+	flags := flag.NewFlagSet("build_images", flag.ExitOnError)
+	config.register(flags)
+	only := flags.String("languages", "", "comma separated languages to build, all languages by default")
+	force := flags.Bool("force", false, "rebuild images that already exist")
+	_ = flags.Parse(args)
+
+	configManager, err := config.load()
+	if err != nil {
+		sandbox.InternalLogger.Errorf("Failed to load config: %v", err)
+		return 1
+	}
+	defer configManager.Close()
+
+	var languages []string
+	if *only != "" {
+		languages = strings.Split(*only, ",")
+	} else {
+		for language := range configManager.GetConfig().Languages {
+			languages = append(languages, language)
+		}
+		sort.Strings(languages)
+	}
+
+	ctx := context.Background()
+	failed := 0
+	for _, language := range languages {
+		languageConfig := configManager.GetLanguageConfig(language)
+		if languageConfig.Prebuilt == nil || len(languageConfig.Prebuilt.Packages) == 0 {
+			continue
+		}
+
+		versions := languageConfig.Prebuilt.Versions
+		if len(versions) == 0 {
+			versions = []string{languageConfig.DefaultImage}
+		}
+		for _, version := range versions {
+			fmt.Printf("Building %s %s with %s\n", language, version, strings.Join(languageConfig.Prebuilt.Packages, " "))
+			tag, err := docker.BuildPrebuiltImage(ctx, newSandboxConfig(configManager, language, version), newRegistryAuthResolver(configManager), *force, os.Stdout)
+			if err != nil {
+				failed++
+				fmt.Printf("FAIL  %s %s: %v\n", language, version, err)
+				continue
+			}
+			fmt.Printf("OK    %s %s: %s\n", language, version, tag)
+		}
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox/docker"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runConfig Inspect the config file: config check.
+func runConfig(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: code-sandbox-mcp-server config check|effective [flags]")
+		return 2
+	}
+
+	switch args[0] {
+	case "check":
+		return checkConfig(args[1:])
+	case "effective":
+		return printEffectiveConfig(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown config command %q, expected check or effective\n", args[0])
+		return 2
+	}
+}
+
+// checkConfig Print every problem of the config file, the exit code is 1 if there is any.
+func checkConfig(args []string) int {
+	var config configFlags
+	flags := flag.NewFlagSet("config check", flag.ExitOnError)
+	config.register(flags)
+	_ = flags.Parse(args)
+
+	path, problems := sandbox.CheckConfig(config.ConfigPath, sandbox.WithFragmentsDir(config.ConfigDir))
+	for _, problem := range problems {
+		fmt.Printf("FAIL  %v\n", problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%s: %d problems found\n", path, len(problems))
+		return 1
+	}
+	fmt.Printf("%s: ok\n", path)
+	return 0
+}
+
+// printEffectiveConfig Print the sandbox config resolved for a language version, with the layer of each resource.
+func printEffectiveConfig(args []string) int {
+	var configLocation configFlags
+	flags := flag.NewFlagSet("config effective", flag.ExitOnError)
+	configLocation.register(flags)
+	language := flags.String("language", "", "language to resolve")
+	version := flags.String("version", "", "version to resolve, the default version of the language if empty")
+	_ = flags.Parse(args)
+
+	configManager, err := configLocation.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+	defer configManager.Close()
+	if _, ok := configManager.GetConfig().Languages[*language]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown language %q\n", *language)
+		return 2
+	}
+
+	config := newSandboxConfig(configManager, *language, *version)
+	_, sources := configManager.GetConfig().ResolveResources(*language, *version)
+	baseImage, image, err := docker.ResolveImages(context.Background(), config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "language\t%s\n", config.Language)
+	fmt.Fprintf(w, "version\t%s\n", config.Version)
+	fmt.Fprintf(w, "base image\t%s\n", baseImage)
+	fmt.Fprintf(w, "image\t%s\n", image)
+	fmt.Fprintf(w, "pull policy\t%s\n", valueOr(config.PullPolicy, sandbox.PullIfNotPresent))
+	fmt.Fprintf(w, "network\t%t\n", config.NetWork != nil && config.NetWork.Enabled)
+	fmt.Fprintf(w, "deadline\t%s\n", valueOr(durationOrEmpty(config.Timeout), "none"))
+	fmt.Fprintf(w, "cpu timeout\t%s (%s)\n", config.Resource.CpuTimeout, sources.CpuTimeout)
+	fmt.Fprintf(w, "memory\t%d MB (%s)\n", config.Resource.MemoryMb, sources.MemoryMb)
+	fmt.Fprintf(w, "disk\t%d MB (%s)\n", config.Resource.DiskMb, sources.DiskMb)
+	fmt.Fprintf(w, "cpus\t%s (%s)\n", formatCpus(config.Resource.Cpus), sources.Cpus)
+	for _, phase := range []struct {
+		name   string
+		config *sandbox.PhaseConfig
+	}{
+		{"install", config.Install},
+		{"compile", config.Compile},
+		{"run", config.Run},
+	} {
+		if phase.config != nil {
+			fmt.Fprintf(w, "%s\t%s (timeout %s)\n", phase.name, strings.Join(phase.config.Entrypoint, " "), phase.config.Timeout)
+		}
+	}
+	for _, volume := range config.CacheVolumes {
+		fmt.Fprintf(w, "cache volume\t%s:%s\n", volume.Name, volume.Target)
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// durationOrEmpty Format a duration, empty if it is 0.
+func durationOrEmpty(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// valueOr Return value, or fallback if it is empty.
+func valueOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox/docker"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// sandboxImage Image rendered from the config for a language version.
+type sandboxImage struct {
+	Language  string
+	Version   string
+	BaseImage string // image pulled from the registry
+	Image     string // image the sandbox runs, the derived image when prebuilt packages are declared
+}
+
+// runImages Manage the sandbox images: images pull|list|prune.
+func runImages(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: code-sandbox-mcp-server images pull|list|prune [flags]")
+		return 2
+	}
+
+	var config configFlags
+	flags := flag.NewFlagSet("images "+args[0], flag.ExitOnError)
+	config.register(flags)
+	only := flags.String("languages", "", "comma separated languages, all languages by default")
+	base := flags.Bool("base", false, "prune: also remove the base images pulled by the server or images pull")
+	_ = flags.Parse(args[1:])
+
+	configManager, err := config.load()
+	if err != nil {
+		sandbox.InternalLogger.Errorf("Failed to load config: %v", err)
+		return 1
+	}
+	defer configManager.Close()
+
+	// Prune keeps the images of every language, not only the selected ones.
+	if args[0] == "prune" {
+		*only = ""
+	}
+
+	ctx := context.Background()
+	images, err := renderSandboxImages(ctx, configManager, *only)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	manager, err := docker.NewImageManager(newRegistryAuthResolver(configManager), newPullRecord(configManager))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer manager.Close()
+
+	switch args[0] {
+	case "pull":
+		return pullImages(ctx, manager, images)
+	case "list":
+		return listImages(ctx, manager, images)
+	case "prune":
+		return pruneImages(ctx, manager, images, *base)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown images command %q, expected pull, list or prune\n", args[0])
+		return 2
+	}
+}
+
+// renderSandboxImages Render the image of every language version from the base_image template.
+func renderSandboxImages(ctx context.Context, configManager *sandbox.ConfigManager, only string) ([]sandboxImage, error) {
+	var languages []string
+	if only != "" {
+		languages = strings.Split(only, ",")
+	} else {
+		for language := range configManager.GetConfig().Languages {
+			languages = append(languages, language)
+		}
+		sort.Strings(languages)
+	}
+
+	var images []sandboxImage
+	for _, language := range languages {
+		for _, version := range languageVersions(configManager, language) {
+			baseImage, image, err := docker.ResolveImages(ctx, newSandboxConfig(configManager, language, version))
+			if err != nil {
+				return nil, fmt.Errorf("failed to render image of %s %s: %w", language, version, err)
+			}
+			images = append(images, sandboxImage{
+				Language:  language,
+				Version:   version,
+				BaseImage: baseImage,
+				Image:     image,
+			})
+		}
+	}
+	return images, nil
+}
+
+// languageVersions Return the default version followed by the other configured versions of the language.
+func languageVersions(configManager *sandbox.ConfigManager, language string) []string {
+	languageConfig := configManager.GetLanguageConfig(language)
+	versions := []string{languageConfig.DefaultImage}
+	seen := map[string]bool{languageConfig.DefaultImage: true}
+
+	candidates := append([]string{}, languageConfig.Versions...)
+	if languageConfig.Prebuilt != nil {
+		candidates = append(candidates, languageConfig.Prebuilt.Versions...)
+	}
+	for _, version := range candidates {
+		if !seen[version] {
+			seen[version] = true
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// pullImages Pull the base image of every language version.
+func pullImages(ctx context.Context, manager *docker.ImageManager, images []sandboxImage) int {
+	pulled := map[string]bool{}
+	failed := 0
+	for _, image := range images {
+		if pulled[image.BaseImage] {
+			continue
+		}
+		pulled[image.BaseImage] = true
+
+		fmt.Printf("Pulling %s (%s %s)\n", image.BaseImage, image.Language, image.Version)
+		if err := manager.Pull(ctx, image.BaseImage, os.Stdout); err != nil {
+			failed++
+			fmt.Printf("FAIL  %v\n", err)
+		}
+		if image.Image != image.BaseImage {
+			fmt.Printf("%s %s runs the derived image %s, build it with build_images\n", image.Language, image.Version, image.Image)
+		}
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// listImages Print the local availability of every language version image.
+func listImages(ctx context.Context, manager *docker.ImageManager, images []sandboxImage) int {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LANGUAGE\tVERSION\tIMAGE\tSTATUS")
+	for _, image := range images {
+		refs := []string{image.BaseImage}
+		if image.Image != image.BaseImage {
+			refs = append(refs, image.Image)
+		}
+		for _, ref := range refs {
+			status := "missing"
+			exists, err := manager.Exists(ctx, ref)
+			switch {
+			case err != nil:
+				status = "error: " + err.Error()
+			case exists:
+				status = "present"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", image.Language, image.Version, ref, status)
+		}
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// pruneImages Remove the outdated derived images no language version uses any more and, with base,
+// the recorded base images no language version uses any more.
+func pruneImages(ctx context.Context, manager *docker.ImageManager, images []sandboxImage, base bool) int {
+	keep := make([]string, 0, 2*len(images))
+	for _, image := range images {
+		keep = append(keep, image.BaseImage, image.Image)
+	}
+
+	removed, err := manager.Prune(ctx, keep, base)
+	for _, ref := range removed {
+		fmt.Printf("Removed %s\n", ref)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%d images removed\n", len(removed))
+	return 0
+}
@@ -2,32 +2,159 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/google/uuid"
+	"github.com/lemonlyue/code-sandbox-mcp/auth"
+	"github.com/lemonlyue/code-sandbox-mcp/compilecache"
 	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
 	"github.com/lemonlyue/code-sandbox-mcp/sandbox/docker"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	mcp "trpc.group/trpc-go/trpc-mcp-go"
 )
 
+// instanceID ID of this server process, the sandbox containers are labeled with it.
+var instanceID = uuid.NewString()
+
+// Defaults of the orphan container reaper.
+const (
+	defaultReaperInterval = time.Minute
+	defaultSandboxTTL     = 15 * time.Minute
+	defaultDrainTimeout   = 30 * time.Second
+	// Defaults of the engine health checks and circuit breaker.
+	defaultHealthInterval     = 10 * time.Second
+	defaultFailureThreshold   = 3
+	defaultBreakerOpenTimeout = 30 * time.Second
+)
+
+// Defaults of the listener.
+const (
+	defaultAddress         = ":4000"
+	defaultSSEEndpoint     = "/sse"
+	defaultMessageEndpoint = "/message"
+)
+
+// subcommands Maps a CLI subcommand to its entry, the returned value is the process exit code.
+var subcommands = map[string]func(args []string) int{
+	"selftest":     runSelfTest,
+	"build_images": runBuildImages,
+	"images":       runImages,
+	"config":       runConfig,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+	os.Exit(runServer(os.Args[1:]))
+}
+
+// runServer Serve until a shutdown signal, the returned value is the process exit code,
+// 1 if the server failed to start so that supervisors restart it.
+func runServer(args []string) int {
 	// Initialize Configuration
-	configManager, err := sandbox.NewConfigManager()
+	flags := parseServerFlags(args)
+	configManager, err := flags.load()
 	if err != nil {
 		sandbox.InternalLogger.Errorf("Failed to load config: %v", err)
-		return
+		return 1
 	}
+	defer configManager.Close()
+	sandbox.InternalLogger.Infof("Loaded config %s", configManager.Path())
+	listen := resolveListenOptions(configManager, flags.Listen)
+
+	var tlsReloader *tlsReloader
+	if listen.CertFile != "" || listen.KeyFile != "" {
+		tlsReloader, err = newTLSReloader(listen.CertFile, listen.KeyFile, listen.ClientCAFile)
+		if err != nil {
+			sandbox.InternalLogger.Errorf("Failed to load TLS certificates: %v", err)
+			return 1
+		}
+	}
+
+	artifactCache, err := newArtifactCache(configManager)
+	if err != nil {
+		sandbox.InternalLogger.Errorf("Failed to create compile cache: %v", err)
+		return 1
+	}
+
+	// The executions share one engine client, the server does not start without the daemon.
+	engine, err := docker.NewClient(context.Background())
+	if err != nil {
+		sandbox.InternalLogger.Errorf("Failed to connect to the Docker engine: %v", err)
+		return 1
+	}
+	defer engine.Close()
+
+	// Remove the containers leaked by previous runs before serving.
+	reaper := newReaper(configManager, engine)
+	removed, err := reaper.Reconcile(context.Background())
+	if err != nil {
+		sandbox.InternalLogger.Errorf("Failed to reconcile sandbox containers: %v", err)
+	} else if removed > 0 {
+		sandbox.InternalLogger.Warnf("Removed %d expired or left over sandbox containers", removed)
+	}
+
+	service := &sandboxService{
+		configManager: configManager,
+		tracker:       sandbox.NewTracker(),
+		limiter:       newLimiter(configManager),
+		quota:         newQuotaManager(configManager),
+		breaker:       sandbox.NewBreaker(breakerConfig(configManager)),
+		engine:        engine,
+	}
+	service.monitor = docker.NewMonitor(engine, service.breaker, healthInterval(configManager))
+	service.monitor.Check(context.Background())
+	service.factory.Store(newSandboxFactory(configManager, artifactCache, engine))
+	// Apply the reloaded limits and registry credentials to the running server.
+	configManager.Subscribe(func(old *sandbox.SandboxConfig, new *sandbox.SandboxConfig) {
+		service.factory.Store(newSandboxFactory(configManager, artifactCache, engine))
+		service.limiter.SetConfig(limiterConfig(configManager))
+		service.quota.SetConfig(quotaConfig(configManager))
+		service.breaker.SetConfig(breakerConfig(configManager))
+	})
 
 	// Create SSE server.
+	httpServer := &http.Server{Addr: listen.Address}
+	if tlsReloader != nil {
+		httpServer.TLSConfig = tlsReloader.TLSConfig()
+	}
 	server := mcp.NewSSEServer(
-		configManager.GetServerConfig().Name,    // Server name.
-		configManager.GetServerConfig().Version, // Server version.
-		mcp.WithSSEEndpoint("/sse"),             // Explicitly set SSE endpoint.
-		mcp.WithMessageEndpoint("/message"),     // Explicitly set message endpoint.
+		configManager.GetServerConfig().Name,            // Server name.
+		configManager.GetServerConfig().Version,         // Server version.
+		mcp.WithSSEEndpoint(listen.SSEEndpoint),         // Explicitly set SSE endpoint.
+		mcp.WithMessageEndpoint(listen.MessageEndpoint), // Explicitly set message endpoint.
+		mcp.WithHTTPServer(httpServer),                  // Shut down with the server.
+		mcp.WithSSEContextFunc(withRemoteIP),            // Identify the clients of the quotas.
 	)
+	httpServer.Handler = server
+	if configManager.GetServerConfig().Auth.Enabled {
+		authenticator, err := newAuthenticator(configManager)
+		if err != nil {
+			sandbox.InternalLogger.Errorf("Failed to create authenticator: %v", err)
+			return 1
+		}
+		defer authenticator.Close()
+		httpServer.Handler = authenticator.Middleware(server)
+		sandbox.InternalLogger.Infof("API key authentication enabled")
+	} else {
+		sandbox.InternalLogger.Warnf("API key authentication disabled, anyone reaching the server can run code")
+	}
+	// The orchestrator probes are not authenticated.
+	httpServer.Handler = withProbes(service, httpServer.Handler)
 
 	// Register notification handlers
 	registerNotificationHandlers(server)
@@ -38,19 +165,32 @@ func main() {
 		mcp.WithString("language", mcp.Required(), mcp.Description("编程语言 | Programming language")),
 		mcp.WithString("code", mcp.Required(), mcp.Description("需要执行的代码 | The code to be executed")),
 		mcp.WithString("version", mcp.Description("编程语言版本 | Programming language version")),
+		mcp.WithArray("dependencies", mcp.Description("运行前安装的依赖包 | Packages installed before the run, e.g. requests==2.31.0, github.com/google/uuid@v1.6.0")),
+		mcp.WithNumber("timeout_seconds", mcp.Description("运行超时秒数，不超过配置上限 | Run timeout in seconds, capped by the configured maximum")),
+		mcp.WithNumber("memory_mb", mcp.Description("内存限制(MB)，不超过配置上限 | Memory limit in MB, capped by the configured maximum")),
+		mcp.WithNumber("cpus", mcp.Description("可用 CPU 数，不超过配置上限 | CPUs available to the code, capped by the configured maximum")),
 	)
-	server.RegisterTool(sandboxTool, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		return sandboxHandler(ctx, req, configManager)
-	})
+	server.RegisterTool(sandboxTool, withToolPolicy(configManager, sandboxTool.Name, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx = withProgressNotifications(ctx, server, req)
+		return service.sandboxHandler(ctx, req)
+	}))
+	quotaTool := mcp.NewTool("get_quota",
+		mcp.WithDescription("查询剩余的执行配额 | Get the remaining execution rate limit and quota of the client"),
+	)
+	server.RegisterTool(quotaTool, withToolPolicy(configManager, quotaTool.Name, service.getQuotaHandler))
 
-	sandbox.InternalLogger.Infof("Registered tools: execute_code_in_sandbox")
-	sandbox.InternalLogger.Infof("SSE endpoint: /sse")
-	sandbox.InternalLogger.Infof("Message endpoint: /message")
+	sandbox.InternalLogger.Infof("Registered tools: execute_code_in_sandbox, get_quota")
+	sandbox.InternalLogger.Infof("SSE endpoint: %s", listen.SSEEndpoint)
+	sandbox.InternalLogger.Infof("Message endpoint: %s", listen.MessageEndpoint)
+	sandbox.InternalLogger.Infof("Probe endpoints: %s, %s, %s", healthzEndpoint, readyzEndpoint, versionEndpoint)
 
 	// Set graceful exit.
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	go reaper.Run(ctx)
+	go service.monitor.Run(ctx)
+
 	// Handle signals.
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
@@ -61,10 +201,21 @@ func main() {
 	}()
 
 	// Start server.
-	sandbox.InternalLogger.Infof("Starting SSE server on port 4000...")
+	var startFailed atomic.Bool
 	go func() {
-		if err := server.Start(":4000"); err != nil {
+		var err error
+		if tlsReloader != nil {
+			sandbox.InternalLogger.Infof("Starting SSE server with TLS on %s...", listen.Address)
+			// The certificates come from the TLS config.
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			sandbox.InternalLogger.Infof("Starting SSE server on %s...", listen.Address)
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			sandbox.InternalLogger.Errorf("Server failed to start: %v", err)
+			startFailed.Store(true)
+			cancel()
 		}
 	}()
 
@@ -72,6 +223,32 @@ func main() {
 	<-ctx.Done()
 	sandbox.InternalLogger.Infof("Shutting down server...")
 
+	// Stop accepting executions and wait for the running ones.
+	drainTimeout := configManager.GetRuntimesConfig().DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	sandbox.InternalLogger.Infof("Draining %d running executions for up to %s", service.tracker.Active(), drainTimeout)
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+	if err := service.tracker.Drain(drainCtx); err != nil {
+		sandbox.InternalLogger.Warnf("Drain period expired with %d executions still running", service.tracker.Active())
+	}
+	drainCancel()
+
+	// Force-remove the remaining containers and temp files.
+	if configManager.GetRuntimesConfig().CleanupOnExit {
+		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), defaultDrainTimeout)
+		if err := service.tracker.Cleanup(cleanupCtx); err != nil {
+			sandbox.InternalLogger.Errorf("Failed to clean up sandboxes: %v", err)
+		}
+		if removed, err := reaper.RemoveInstance(cleanupCtx); err != nil {
+			sandbox.InternalLogger.Errorf("Failed to remove sandbox containers: %v", err)
+		} else if removed > 0 {
+			sandbox.InternalLogger.Infof("Removed %d sandbox containers", removed)
+		}
+		cleanupCancel()
+	}
+
 	// Graceful exit.
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer shutdownCancel()
@@ -80,11 +257,28 @@ func main() {
 		sandbox.InternalLogger.Errorf("Server shutdown failed: %v", err)
 	}
 
+	if startFailed.Load() {
+		return 1
+	}
 	sandbox.InternalLogger.Infof("Server gracefully stopped")
+	return 0
+}
+
+// sandboxService Dependencies shared by the tool handlers.
+type sandboxService struct {
+	configManager *sandbox.ConfigManager
+	factory       atomic.Pointer[sandbox.Factory] // sandbox factory of the current config
+	tracker       *sandbox.Tracker                // running executions, drained on shutdown
+	limiter       *sandbox.Limiter                // concurrency limits and execution queue
+	quota         *sandbox.QuotaManager
+	breaker       *sandbox.Breaker // fails the executions fast while the engine is unavailable
+	monitor       *docker.Monitor  // health checks of the engine
+	engine        *docker.Client
 }
 
 // sandboxHandler handles greet tool callback function.
-func sandboxHandler(ctx context.Context, request *mcp.CallToolRequest, configManager *sandbox.ConfigManager) (*mcp.CallToolResult, error) {
+func (s *sandboxService) sandboxHandler(ctx context.Context, request *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	configManager := s.configManager
 	select {
 	case <-ctx.Done():
 		return mcp.NewErrorResult("Request cancelled"), ctx.Err()
@@ -105,48 +299,562 @@ func sandboxHandler(ctx context.Context, request *mcp.CallToolRequest, configMan
 	if !codeOk {
 		return nil, fmt.Errorf("missing required argument: 'code'")
 	}
+	dependencies, err := parseDependencies(args["dependencies"])
+	if err != nil {
+		return nil, err
+	}
+	resourceRequest, err := parseResourceRequest(args)
+	if err != nil {
+		return mcp.NewErrorResult(err.Error()), nil
+	}
 
-	dockerCreatorFunc := docker.NewDockerSandboxCreator()
+	if err := s.tracker.Acquire(); err != nil {
+		return mcp.NewErrorResult("Server is shutting down, execution rejected"), nil
+	}
+	var sb sandbox.Sandbox
+	defer func() {
+		s.tracker.Release(sb)
+	}()
 
-	factory := sandbox.NewFactory(
-		sandbox.WithDockerCreator(dockerCreatorFunc),
-	)
+	factory := s.factory.Load()
 
-	languageConfig := configManager.GetLanguageConfig(language)
-	config := &sandbox.Config{
-		Language:   language,
-		Version:    version,
-		Image:      languageConfig.BaseImage,
-		BaseImage:  languageConfig.DefaultImage,
-		Entrypoint: languageConfig.Entrypoint,
-		Suffix:     languageConfig.Suffix,
-		Resource: &sandbox.ResourceConfig{
-			CpuTimeout: languageConfig.Resources.CpuTimeout,
-			MemoryMb:   languageConfig.Resources.MemoryMb,
-			DiskMb:     languageConfig.Resources.DiskMb,
-		},
+	config := newSandboxConfig(configManager, language, version)
+	if len(dependencies) > 0 {
+		languageConfig := configManager.GetLanguageConfig(language)
+		if languageConfig.Dependencies == nil {
+			return mcp.NewErrorResult(fmt.Sprintf("Language %s does not support dependencies", language)), nil
+		}
+		if err := sandbox.ValidateDependencies(languageConfig.Dependencies.Manager, languageConfig.Dependencies.Allowlist, dependencies); err != nil {
+			return mcp.NewErrorResult(err.Error()), nil
+		}
+		config.Dependencies = dependencies
+	}
+	configManager.GetConfig().ApplyResourceRequest(config, resourceRequest)
+
+	client := clientID(ctx, configManager)
+	sandbox.InternalLogger.Infof("Execution of %s requested by %s", language, client)
+	if err := clientPolicy(ctx, configManager).Authorize(config); err != nil {
+		sandbox.InternalLogger.Warnf("Execution of %s rejected for %s by policy: %v", language, client, err)
+		return mcp.NewErrorResult(fmt.Sprintf("Execution rejected by policy: %v", err)), nil
+	}
+	status, refund, err := s.quota.Allow(client)
+	if err != nil {
+		sandbox.InternalLogger.Warnf("Execution of %s rejected for %s: %v", language, client, err)
+		return mcp.NewErrorResult(fmt.Sprintf("Execution rejected: %v\n%s", err, formatQuotaStatus(status))), nil
+	}
+
+	release, err := s.limiter.Acquire(ctx, language)
+	if err != nil {
+		refund()
+		running, queued := s.limiter.Stats()
+		sandbox.InternalLogger.Warnf("Execution of %s rejected (%d running, %d queued): %v", language, running, queued, err)
+		return mcp.NewErrorResult(fmt.Sprintf("Server is busy: %v", err)), nil
 	}
-	sb, err := factory.Create(context.Background(), config)
+	defer release()
+
+	if err := s.breaker.Allow(); err != nil {
+		refund()
+		_, reason := s.breaker.State()
+		sandbox.InternalLogger.Warnf("Execution of %s rejected: %v (%v)", language, err, reason)
+		return mcp.NewErrorResult("Sandbox engine unavailable, try again later"), nil
+	}
+	sb, err = factory.Create(context.Background(), config)
 	if err != nil {
-		panic(err)
+		refund()
+		s.reportEngine(err)
+		sandbox.InternalLogger.Errorf("Failed to create sandbox: %v", err)
+		return mcp.NewErrorResult(fmt.Sprintf("Failed to create sandbox: %v", err)), nil
 	}
+	s.tracker.Add(sb)
 	execute, err := sb.Execute(ctx, code)
+	s.reportEngine(err)
+	if docker.IsEngineUnavailable(err) {
+		sandbox.InternalLogger.Errorf("Execution of %s failed, the engine is unavailable: %v", language, err)
+		return mcp.NewErrorResult("Sandbox engine unavailable, try again later"), nil
+	}
+	var deadlineErr *sandbox.DeadlineError
+	if errors.As(err, &deadlineErr) {
+		s.quota.Charge(client, 0, deadlineErr.Timeout)
+		sandbox.InternalLogger.Warnf("Execution of %s for %s: %v", language, client, err)
+		return mcp.NewErrorResult(fmt.Sprintf("Execution timed out after %s during the %s phase\n%s", deadlineErr.Timeout, deadlineErr.Phase, formatTimings(execute.Timings))), nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute in sandbox: %w", err)
 	}
-	var result string
+	s.quota.Charge(client, execute.CpuTime, execute.Duration)
+	result := formatExecutionResult(execute)
 
-	if execute.Stderr == "" {
+	if execute.Compile != nil {
+		sandbox.InternalLogger.Infof("Code compilation exit code: %v, duration: %s", execute.Compile.ExitCode, execute.Compile.Duration)
+	}
+	if execute.Stderr == "" && !execute.InstallFailed() && !execute.CompileFailed() {
 		sandbox.InternalLogger.Infof("Code execution stdout: %s", execute.Stdout)
-		result = execute.Stdout
 	} else {
-		result = execute.Stderr
-		sandbox.InternalLogger.Errorf("Code execution stderr: %s", execute.Stderr)
+		sandbox.InternalLogger.Errorf("Code execution stderr: %s", result)
 	}
 	sandbox.InternalLogger.Infof("Code execution exit code: %v", execute.ExitCode)
-	sandbox.InternalLogger.Infof("Code execution duration: %s", execute.Duration)
+	sandbox.InternalLogger.Infof("Code execution duration: %s, cpu time: %s", execute.Duration, execute.CpuTime)
+	sandbox.InternalLogger.Infof("Code execution %s", formatTimings(execute.Timings))
+
+	toolResult := mcp.NewTextResult(result)
+	toolResult.Content = append(toolResult.Content, mcp.NewTextContent(formatLimits(config)+"\n"+formatTimings(execute.Timings)))
+	return toolResult, nil
+}
+
+// reportEngine Report the outcome of an engine call to the circuit breaker,
+// only the failures to reach the engine count against it.
+func (s *sandboxService) reportEngine(err error) {
+	if docker.IsEngineUnavailable(err) {
+		s.breaker.Failure(err)
+		return
+	}
+	s.breaker.Success()
+}
+
+// newSandboxConfig Build the sandbox config of the language from the config file.
+func newSandboxConfig(configManager *sandbox.ConfigManager, language string, version string) *sandbox.Config {
+	snapshot := configManager.GetConfig()
+	languageConfig := snapshot.Languages[language]
+	resources, _ := snapshot.ResolveResources(language, version)
+
+	// The run phase falls back to the single entrypoint bounded by the cpu timeout.
+	run := &sandbox.PhaseConfig{
+		Entrypoint: languageConfig.Entrypoint,
+		Timeout:    resources.CpuTimeout,
+	}
+	if languageConfig.Run != nil {
+		run.Entrypoint = languageConfig.Run.Entrypoint
+		if languageConfig.Run.Timeout > 0 {
+			run.Timeout = languageConfig.Run.Timeout
+		}
+	}
+
+	var compile *sandbox.PhaseConfig
+	if languageConfig.Compile != nil {
+		compile = &sandbox.PhaseConfig{
+			Entrypoint: languageConfig.Compile.Entrypoint,
+			Timeout:    languageConfig.Compile.Timeout,
+			Artifact:   languageConfig.Compile.Artifact,
+		}
+		if compile.Timeout <= 0 {
+			compile.Timeout = resources.CpuTimeout
+		}
+	}
+
+	var install *sandbox.PhaseConfig
+	if languageConfig.Dependencies != nil {
+		install = &sandbox.PhaseConfig{
+			Entrypoint: languageConfig.Dependencies.Install,
+			Timeout:    languageConfig.Dependencies.Timeout,
+		}
+		if install.Timeout <= 0 {
+			install.Timeout = resources.CpuTimeout
+		}
+	}
+
+	cacheVolumes := make([]sandbox.VolumeConfig, 0, len(languageConfig.CacheVolumes))
+	for _, volume := range languageConfig.CacheVolumes {
+		cacheVolumes = append(cacheVolumes, sandbox.VolumeConfig{
+			Name:   volume.Name,
+			Target: volume.Target,
+		})
+	}
+
+	var prebuilt *sandbox.PrebuiltConfig
+	if languageConfig.Prebuilt != nil && len(languageConfig.Prebuilt.Packages) > 0 {
+		prebuilt = &sandbox.PrebuiltConfig{
+			Packages: languageConfig.Prebuilt.Packages,
+			Install:  languageConfig.Prebuilt.Install,
+		}
+	}
 
-	return mcp.NewTextResult(result), nil
+	// Digests are pinned per version, the default version is used when none is requested.
+	var digest string
+	pinnedVersion := version
+	if pinnedVersion == "" {
+		pinnedVersion = languageConfig.DefaultImage
+	}
+	for _, pinned := range languageConfig.Digests {
+		if pinned.Version == pinnedVersion {
+			digest = pinned.Digest
+		}
+	}
+
+	return &sandbox.Config{
+		Language:            language,
+		Version:             version,
+		Image:               languageConfig.BaseImage,
+		BaseImage:           languageConfig.DefaultImage,
+		Install:             install,
+		Compile:             compile,
+		Run:                 run,
+		Suffix:              languageConfig.Suffix,
+		CacheVolumes:        cacheVolumes,
+		Prebuilt:            prebuilt,
+		PullPolicy:          languageConfig.PullPolicy,
+		VersionPattern:      languageConfig.VersionPattern,
+		AllowedRepositories: snapshot.Runtimes.Images.AllowedRepositories,
+		ImageDigest:         digest,
+		Timeout:             time.Duration(snapshot.Runtimes.Timeout) * time.Second,
+		Resource:            &resources,
+		NetWork: &sandbox.NetWorkConfig{
+			Enabled: snapshot.Runtimes.Network.Enabled,
+		},
+	}
+}
+
+// parseDependencies Parse the optional dependencies argument.
+func parseDependencies(value interface{}) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid argument 'dependencies', expected an array of strings")
+	}
+	dependencies := make([]string, 0, len(items))
+	for _, item := range items {
+		dependency, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid argument 'dependencies', expected an array of strings")
+		}
+		dependencies = append(dependencies, dependency)
+	}
+	return dependencies, nil
+}
+
+// parseResourceRequest Parse the optional timeout_seconds, memory_mb and cpus arguments.
+func parseResourceRequest(args map[string]interface{}) (sandbox.ResourceRequest, error) {
+	var request sandbox.ResourceRequest
+	for _, field := range []struct {
+		name  string
+		apply func(value float64)
+	}{
+		{"timeout_seconds", func(value float64) { request.Timeout = time.Duration(value * float64(time.Second)) }},
+		{"memory_mb", func(value float64) { request.MemoryMb = int64(value) }},
+		{"cpus", func(value float64) { request.Cpus = value }},
+	} {
+		if args[field.name] == nil {
+			continue
+		}
+		value, ok := args[field.name].(float64)
+		if !ok {
+			return request, fmt.Errorf("invalid argument '%s', expected a number", field.name)
+		}
+		field.apply(value)
+	}
+	return request, request.Validate()
+}
+
+// formatLimits Describe the effective limits of the execution.
+func formatLimits(config *sandbox.Config) string {
+	return fmt.Sprintf("Limits: timeout %s, memory %d MB, cpus %s", config.Run.Timeout, config.Resource.MemoryMb, formatCpus(config.Resource.Cpus))
+}
+
+// formatTimings Describe the duration of each phase of the execution.
+func formatTimings(timings []sandbox.PhaseTiming) string {
+	parts := make([]string, 0, len(timings))
+	for _, timing := range timings {
+		parts = append(parts, fmt.Sprintf("%s %s", timing.Phase, timing.Duration.Round(time.Millisecond)))
+	}
+	return "Timings: " + strings.Join(parts, ", ")
+}
+
+// formatCpus Format a CPU limit, 0 is unlimited.
+func formatCpus(cpus float64) string {
+	if cpus <= 0 {
+		return "unlimited"
+	}
+	return strconv.FormatFloat(cpus, 'f', -1, 64)
+}
+
+// newSandboxFactory Create the sandbox factory of the configured engine.
+func newSandboxFactory(configManager *sandbox.ConfigManager, artifactCache sandbox.ArtifactCache, engine *docker.Client) *sandbox.Factory {
+	creatorOpts := []docker.CreatorOption{
+		docker.WithClient(engine),
+		docker.WithRetryPolicy(retryPolicy(configManager)),
+		docker.WithRegistryAuth(newRegistryAuthResolver(configManager)),
+		docker.WithPullRecord(newPullRecord(configManager)),
+		docker.WithInstance(instanceID, configManager.GetRuntimesConfig().Reaper.Instance, sandboxTTL(configManager)),
+	}
+	if artifactCache != nil {
+		creatorOpts = append(creatorOpts, docker.WithArtifactCache(artifactCache))
+	}
+	dockerCreatorFunc := docker.NewDockerSandboxCreator(creatorOpts...)
+
+	return sandbox.NewFactory(
+		sandbox.WithDockerCreator(dockerCreatorFunc),
+	)
+}
+
+// listenOptions Address, endpoints and TLS files the server listens with.
+type listenOptions struct {
+	Address         string
+	SSEEndpoint     string
+	MessageEndpoint string
+	CertFile        string
+	KeyFile         string
+	ClientCAFile    string // enables mTLS
+}
+
+// configFlags CLI flags locating the config, shared by the server and the subcommands.
+type configFlags struct {
+	ConfigPath string
+	ConfigDir  string
+}
+
+// register Register the config flags on flags.
+func (c *configFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.ConfigPath, "config", "", "path of the config file (env SANDBOX_CONFIG)")
+	flags.StringVar(&c.ConfigDir, "config-dir", "", "directory of the config fragments, conf.d next to the config file by default (env SANDBOX_CONFIG_DIR)")
+}
+
+// load Load the config, the returned manager watches it and must be closed.
+func (c configFlags) load() (*sandbox.ConfigManager, error) {
+	return sandbox.NewConfigManager(c.ConfigPath, sandbox.WithFragmentsDir(c.ConfigDir))
+}
+
+// serverFlags CLI flags of the server.
+type serverFlags struct {
+	configFlags
+	Listen listenOptions // the set flags override the config
+}
+
+// parseServerFlags Parse the CLI flags of the server.
+func parseServerFlags(args []string) serverFlags {
+	var parsed serverFlags
+	flags := flag.NewFlagSet("code-sandbox-mcp-server", flag.ExitOnError)
+	parsed.register(flags)
+	flags.StringVar(&parsed.Listen.Address, "address", "", "listen address (env SANDBOX_SERVER_ADDRESS)")
+	flags.StringVar(&parsed.Listen.SSEEndpoint, "sse-endpoint", "", "SSE endpoint (env SANDBOX_SERVER_SSE_ENDPOINT)")
+	flags.StringVar(&parsed.Listen.MessageEndpoint, "message-endpoint", "", "message endpoint (env SANDBOX_SERVER_MESSAGE_ENDPOINT)")
+	flags.StringVar(&parsed.Listen.CertFile, "tls-cert", "", "TLS certificate file (env SANDBOX_SERVER_TLS_CERT_FILE)")
+	flags.StringVar(&parsed.Listen.KeyFile, "tls-key", "", "TLS key file (env SANDBOX_SERVER_TLS_KEY_FILE)")
+	flags.StringVar(&parsed.Listen.ClientCAFile, "tls-client-ca", "", "CA file of the client certificates, enables mTLS (env SANDBOX_SERVER_TLS_CLIENT_CA_FILE)")
+	_ = flags.Parse(args)
+	return parsed
+}
+
+// resolveListenOptions Resolve the listen options from the defaults, the server config
+// (including its SANDBOX_SERVER_* env overrides) and the CLI flags, each overriding the previous ones.
+func resolveListenOptions(configManager *sandbox.ConfigManager, flags listenOptions) listenOptions {
+	serverConfig := configManager.GetServerConfig()
+	options := listenOptions{
+		Address:         valueOr(flags.Address, serverConfig.Address),
+		SSEEndpoint:     valueOr(flags.SSEEndpoint, serverConfig.SSEEndpoint),
+		MessageEndpoint: valueOr(flags.MessageEndpoint, serverConfig.MessageEndpoint),
+		CertFile:        valueOr(flags.CertFile, serverConfig.TLS.CertFile),
+		KeyFile:         valueOr(flags.KeyFile, serverConfig.TLS.KeyFile),
+		ClientCAFile:    valueOr(flags.ClientCAFile, serverConfig.TLS.ClientCAFile),
+	}
+
+	if options.Address == "" {
+		options.Address = defaultAddress
+	}
+	if options.SSEEndpoint == "" {
+		options.SSEEndpoint = defaultSSEEndpoint
+	}
+	if options.MessageEndpoint == "" {
+		options.MessageEndpoint = defaultMessageEndpoint
+	}
+	return options
+}
+
+// newAuthenticator Create the authenticator of the API keys in server.auth.
+func newAuthenticator(configManager *sandbox.ConfigManager) (*auth.Authenticator, error) {
+	configKeys := func() []auth.Key {
+		keys := configManager.GetServerConfig().Auth.Keys
+		result := make([]auth.Key, 0, len(keys))
+		for _, key := range keys {
+			result = append(result, auth.Key{Client: key.Client, Key: key.Key})
+		}
+		return result
+	}
+	return auth.NewAuthenticator(configKeys, configManager.GetServerConfig().Auth.KeysFile)
+}
+
+// breakerConfig Return the circuit breaker thresholds of runtimes.health.
+func breakerConfig(configManager *sandbox.ConfigManager) sandbox.BreakerConfig {
+	health := configManager.GetRuntimesConfig().Health
+	config := sandbox.BreakerConfig{
+		FailureThreshold: health.FailureThreshold,
+		OpenTimeout:      health.OpenTimeout,
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaultFailureThreshold
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	return config
+}
+
+// retryPolicy Return the retry policy of the engine operations from runtimes.retry,
+// the unset fields take the default policy values.
+func retryPolicy(configManager *sandbox.ConfigManager) sandbox.RetryPolicy {
+	retry := configManager.GetRuntimesConfig().Retry
+	policy := sandbox.DefaultRetryPolicy
+	if retry.MaxAttempts > 0 {
+		policy.MaxAttempts = retry.MaxAttempts
+	}
+	if retry.InitialDelay > 0 {
+		policy.InitialDelay = retry.InitialDelay
+	}
+	if retry.MaxDelay > 0 {
+		policy.MaxDelay = retry.MaxDelay
+	}
+	if retry.Multiplier > 0 {
+		policy.Multiplier = retry.Multiplier
+	}
+	if retry.Jitter > 0 {
+		policy.Jitter = retry.Jitter
+	}
+	if retry.MaxElapsed > 0 {
+		policy.MaxElapsed = retry.MaxElapsed
+	}
+	return policy
+}
+
+// healthInterval Return the interval between two health checks of the engine.
+func healthInterval(configManager *sandbox.ConfigManager) time.Duration {
+	if interval := configManager.GetRuntimesConfig().Health.Interval; interval > 0 {
+		return interval
+	}
+	return defaultHealthInterval
+}
+
+// newLimiter Create the concurrency limiter from runtimes.concurrency.
+func newLimiter(configManager *sandbox.ConfigManager) *sandbox.Limiter {
+	return sandbox.NewLimiter(limiterConfig(configManager))
+}
+
+// limiterConfig Return the concurrency limits of runtimes.concurrency.
+func limiterConfig(configManager *sandbox.ConfigManager) sandbox.LimiterConfig {
+	concurrency := configManager.GetRuntimesConfig().Concurrency
+	return sandbox.LimiterConfig{
+		MaxConcurrent: concurrency.MaxConcurrent,
+		Languages:     concurrency.Languages,
+		QueueSize:     concurrency.QueueSize,
+		QueueTimeout:  concurrency.QueueTimeout,
+	}
+}
+
+// newReaper Create the reaper of the containers leaked by this and previous server instances.
+func newReaper(configManager *sandbox.ConfigManager, engine *docker.Client) *docker.Reaper {
+	interval := configManager.GetRuntimesConfig().Reaper.Interval
+	if interval <= 0 {
+		interval = defaultReaperInterval
+	}
+	return docker.NewReaper(engine, instanceID, configManager.GetRuntimesConfig().Reaper.Instance, interval)
+}
+
+// sandboxTTL Return how long a sandbox container may live before it is reaped,
+// the default outlives the execution deadline like the configured ttl must.
+func sandboxTTL(configManager *sandbox.ConfigManager) time.Duration {
+	runtimes := configManager.GetRuntimesConfig()
+	if runtimes.Reaper.TTL > 0 {
+		return runtimes.Reaper.TTL
+	}
+	return max(defaultSandboxTTL, time.Duration(runtimes.Timeout)*time.Second+2*sandbox.ReapMargin)
+}
+
+// newRegistryAuthResolver Create the resolver of the registry credentials from the config.
+func newRegistryAuthResolver(configManager *sandbox.ConfigManager) *docker.RegistryAuthResolver {
+	registry := configManager.GetRuntimesConfig().Registry
+	credentials := make([]sandbox.RegistryCredential, 0, len(registry.Credentials))
+	for _, credential := range registry.Credentials {
+		credentials = append(credentials, sandbox.RegistryCredential{
+			Host:          credential.Host,
+			Username:      credential.Username,
+			Password:      credential.Password,
+			IdentityToken: credential.IdentityToken,
+		})
+	}
+	return docker.NewRegistryAuthResolver(credentials, registry.DockerConfig)
+}
+
+// withProgressNotifications Send the progress reported during the tool call as MCP progress notifications,
+// if the client asked for them with a progress token.
+// The progress of a token must increase with each notification, while each stage of the call (queue, build, pull)
+// reports its own progress from 0, so a progress not above the previous one is raised above it.
+func withProgressNotifications(ctx context.Context, server *mcp.SSEServer, request *mcp.CallToolRequest) context.Context {
+	if request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil {
+		return ctx
+	}
+	session := mcp.ClientSessionFromContext(ctx)
+	if session == nil {
+		return ctx
+	}
+
+	token := request.Params.Meta.ProgressToken
+	var mu sync.Mutex
+	last := -1.0
+	return sandbox.WithProgress(ctx, func(progress float64, total float64, message string) {
+		mu.Lock()
+		if progress <= last {
+			progress = last + 1
+		}
+		last = progress
+		mu.Unlock()
+
+		params := map[string]interface{}{
+			"progressToken": token,
+			"progress":      progress,
+			"message":       message,
+		}
+		if total >= progress && total > 0 {
+			params["total"] = total
+		}
+		if err := server.SendNotification(session.GetID(), mcp.NotificationMethodProgress, params); err != nil {
+			sandbox.InternalLogger.Warnf("failed to send progress notification: %v", err)
+		}
+	})
+}
+
+// newArtifactCache Create the compile cache, nil if it is disabled.
+func newArtifactCache(configManager *sandbox.ConfigManager) (sandbox.ArtifactCache, error) {
+	runtimes := configManager.GetRuntimesConfig()
+	if !runtimes.CompileCache.Enabled {
+		return nil, nil
+	}
+
+	dir := runtimes.CompileCache.Dir
+	if dir == "" {
+		dir = filepath.Join(runtimes.WorkDir, "compile-cache")
+	}
+	return compilecache.NewCache(dir, runtimes.CompileCache.MaxSizeMb, runtimes.CompileCache.MaxEntries)
+}
+
+// newPullRecord Create the record of the pulled base images, shared by the server and the images command.
+func newPullRecord(configManager *sandbox.ConfigManager) *docker.PullRecord {
+	return docker.NewPullRecord(filepath.Join(configManager.GetRuntimesConfig().WorkDir, "pulled-images"))
+}
+
+// formatExecutionResult Format the execution result as the tool output.
+func formatExecutionResult(execute *sandbox.ExecutionResult) string {
+	if execute.InstallFailed() {
+		if execute.Install.TimedOut {
+			return "Dependency installation timeout"
+		}
+		return fmt.Sprintf("Dependency installation failed (exit code %d):\n%s%s", execute.Install.ExitCode, execute.Install.Stdout, execute.Install.Stderr)
+	}
+	if execute.CompileFailed() {
+		if execute.Compile.TimedOut {
+			return "Compilation timeout"
+		}
+		return fmt.Sprintf("Compilation failed (exit code %d):\n%s%s", execute.Compile.ExitCode, execute.Compile.Stdout, execute.Compile.Stderr)
+	}
+	output := execute.Stderr
+	if output == "" {
+		output = execute.Stdout
+	}
+	// The warnings of a successful compilation precede the run output.
+	if execute.Compile != nil {
+		if compileOutput := strings.TrimRight(execute.Compile.Stdout+execute.Compile.Stderr, "\n"); compileOutput != "" {
+			return fmt.Sprintf("Compiler output:\n%s\n\nRun output:\n%s", compileOutput, output)
+		}
+	}
+	return output
 }
 
 // registerNotificationHandlers registers handlers for client notifications
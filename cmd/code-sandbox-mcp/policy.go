@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/lemonlyue/code-sandbox-mcp/auth"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"slices"
+
+	mcp "trpc.group/trpc-go/trpc-mcp-go"
+)
+
+// clientPolicy Return the policy of the authenticated client, the default policy if none lists it.
+func clientPolicy(ctx context.Context, configManager *sandbox.ConfigManager) *sandbox.Policy {
+	authConfig := configManager.GetServerConfig().Auth
+	policy := authConfig.DefaultPolicy
+	if client, ok := auth.ClientFromContext(ctx); ok {
+		for _, candidate := range authConfig.Policies {
+			if slices.Contains(candidate.Clients, client) {
+				policy = candidate
+				break
+			}
+		}
+	}
+
+	return &sandbox.Policy{
+		Languages: policy.Languages,
+		Tools:     policy.Tools,
+		Network:   policy.Network,
+		MaxResources: sandbox.ResourceConfig{
+			CpuTimeout: policy.MaxResources.CpuTimeout,
+			MemoryMb:   policy.MaxResources.MemoryMb,
+			DiskMb:     policy.MaxResources.DiskMb,
+			Cpus:       policy.MaxResources.Cpus,
+		},
+	}
+}
+
+// withToolPolicy Reject the calls of the tool by clients whose policy does not enable it.
+func withToolPolicy(configManager *sandbox.ConfigManager, tool string, handler func(ctx context.Context, request *mcp.CallToolRequest) (*mcp.CallToolResult, error)) func(ctx context.Context, request *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if !clientPolicy(ctx, configManager).AllowsTool(tool) {
+			sandbox.InternalLogger.Warnf("Tool %s rejected for %s by policy", tool, clientID(ctx, configManager))
+			return mcp.NewErrorResult(fmt.Sprintf("Tool %s is not enabled for this client", tool)), nil
+		}
+		return handler(ctx, request)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox/docker"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Endpoints of the probes, served without authentication next to the MCP endpoints.
+const (
+	healthzEndpoint = "/healthz"
+	readyzEndpoint  = "/readyz"
+	versionEndpoint = "/version"
+)
+
+// probeTimeout Max duration of the readiness checks.
+const probeTimeout = 5 * time.Second
+
+// probeCheck Result of a readiness check.
+type probeCheck struct {
+	Ready  bool   `json:"ready"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// readiness Body of /readyz.
+type readiness struct {
+	Ready  bool                  `json:"ready"`
+	Checks map[string]probeCheck `json:"checks"`
+}
+
+// versionInfo Body of /version.
+type versionInfo struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Revision      string `json:"revision,omitempty"` // VCS revision the binary was built from
+	GoVersion     string `json:"go_version"`
+	Instance      string `json:"instance"`
+	EngineVersion string `json:"engine_version,omitempty"`
+}
+
+// withProbes Serve the health, readiness and version probes, the other requests go to next.
+func withProbes(service *sandboxService, next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+healthzEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET "+readyzEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+		result := service.readiness(ctx)
+		status := http.StatusOK
+		if !result.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, result)
+	})
+	mux.HandleFunc("GET "+versionEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, service.version())
+	})
+	mux.Handle("/", next)
+	return mux
+}
+
+// readiness Check the server can run executions: it is not shutting down, the config is loaded,
+// the engine is reachable with its circuit breaker closed and the default images are present.
+func (s *sandboxService) readiness(ctx context.Context) readiness {
+	checks := map[string]probeCheck{
+		"accepting": {Ready: true},
+		"config":    {Ready: s.configManager.GetConfig() != nil, Detail: s.configManager.Path()},
+	}
+	if s.tracker.Draining() {
+		checks["accepting"] = probeCheck{Detail: "shutting down"}
+	}
+
+	engine := s.engineCheck()
+	checks["engine"] = engine
+	if engine.Ready {
+		checks["images"] = s.imagesCheck(ctx)
+	} else {
+		checks["images"] = probeCheck{Detail: "engine unavailable"}
+	}
+
+	result := readiness{Ready: true, Checks: checks}
+	for _, check := range checks {
+		result.Ready = result.Ready && check.Ready
+	}
+	return result
+}
+
+// engineCheck Check the circuit breaker is closed and the last health check of the engine passed.
+func (s *sandboxService) engineCheck() probeCheck {
+	state, reason := s.breaker.State()
+	status := s.monitor.Status()
+	switch {
+	case state != sandbox.BreakerClosed:
+		return probeCheck{Detail: fmt.Sprintf("circuit breaker %s: %v", state, reason)}
+	case !status.Healthy:
+		return probeCheck{Detail: fmt.Sprintf("health check failed at %s: %v", status.Checked.Format(time.RFC3339), status.Err)}
+	default:
+		return probeCheck{Ready: true, Detail: fmt.Sprintf("circuit breaker %s, docker %s", state, status.ServerVersion)}
+	}
+}
+
+// imagesCheck Check the image of the default version of every language is present in the engine.
+func (s *sandboxService) imagesCheck(ctx context.Context) probeCheck {
+	languages := make([]string, 0, len(s.configManager.GetConfig().Languages))
+	for language := range s.configManager.GetConfig().Languages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	var missing []string
+	for _, language := range languages {
+		_, image, err := docker.ResolveImages(ctx, newSandboxConfig(s.configManager, language, ""))
+		if err != nil {
+			return probeCheck{Detail: fmt.Sprintf("failed to render image of %s: %v", language, err)}
+		}
+		exists, err := docker.ImageExists(ctx, s.engine, image)
+		if err != nil {
+			return probeCheck{Detail: fmt.Sprintf("failed to inspect image %s: %v", image, err)}
+		}
+		if !exists {
+			missing = append(missing, image)
+		}
+	}
+	if len(missing) > 0 {
+		return probeCheck{Detail: "missing " + strings.Join(missing, ", ")}
+	}
+	return probeCheck{Ready: true, Detail: fmt.Sprintf("%d images present", len(languages))}
+}
+
+// version Return the build and engine versions.
+func (s *sandboxService) version() versionInfo {
+	serverConfig := s.configManager.GetServerConfig()
+	info := versionInfo{
+		Name:          serverConfig.Name,
+		Version:       serverConfig.Version,
+		GoVersion:     runtime.Version(),
+		Instance:      instanceID,
+		EngineVersion: s.monitor.Status().ServerVersion,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Revision = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// writeJSON Write the value as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		sandbox.InternalLogger.Errorf("Failed to write probe response: %v", err)
+	}
+}
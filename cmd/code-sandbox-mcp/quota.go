@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/lemonlyue/code-sandbox-mcp/auth"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	mcp "trpc.group/trpc-go/trpc-mcp-go"
+)
+
+const (
+	// clientKeySession Identify the clients by their MCP session.
+	clientKeySession = "session"
+	// clientKeyIP Identify the clients by their remote IP.
+	clientKeyIP = "ip"
+	// clientKeyAPIKey Identify the clients by the client of their API key, by remote IP when unauthenticated.
+	clientKeyAPIKey = "api_key"
+)
+
+type remoteIPKey struct{}
+
+// withRemoteIP Store the remote IP of the HTTP request in the context of the MCP request.
+func withRemoteIP(ctx context.Context, r *http.Request) context.Context {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return context.WithValue(ctx, remoteIPKey{}, host)
+}
+
+// clientID Return the identity of the client the quotas are enforced on, following runtimes.quota.client_key.
+func clientID(ctx context.Context, configManager *sandbox.ConfigManager) string {
+	switch configManager.GetRuntimesConfig().Quota.ClientKey {
+	case clientKeySession:
+		if session := mcp.ClientSessionFromContext(ctx); session != nil {
+			return "session:" + session.GetID()
+		}
+	case clientKeyAPIKey:
+		if client, ok := auth.ClientFromContext(ctx); ok {
+			return "key:" + client
+		}
+	}
+	if ip, ok := ctx.Value(remoteIPKey{}).(string); ok && ip != "" {
+		return "ip:" + ip
+	}
+	return "anonymous"
+}
+
+// newQuotaManager Create the quota manager from runtimes.quota.
+func newQuotaManager(configManager *sandbox.ConfigManager) *sandbox.QuotaManager {
+	return sandbox.NewQuotaManager(quotaConfig(configManager))
+}
+
+// quotaConfig Return the per-client limits of runtimes.quota.
+func quotaConfig(configManager *sandbox.ConfigManager) sandbox.QuotaConfig {
+	quota := configManager.GetRuntimesConfig().Quota
+	return sandbox.QuotaConfig{
+		RateLimit:    quota.RateLimit,
+		RateInterval: quota.RateInterval,
+		Window:       quota.Window,
+		CpuTime:      quota.CpuTime,
+		WallTime:     quota.WallTime,
+	}
+}
+
+// getQuotaHandler Return the rate limit and quota usage of the calling client.
+func (s *sandboxService) getQuotaHandler(ctx context.Context, request *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return mcp.NewTextResult(formatQuotaStatus(s.quota.Status(clientID(ctx, s.configManager)))), nil
+}
+
+// formatQuotaStatus Describe the usage and the remaining budget of a client.
+func formatQuotaStatus(status sandbox.QuotaStatus) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Client: %s\n", status.Client)
+	if status.RateLimit > 0 && status.RateInterval > 0 {
+		fmt.Fprintf(&b, "Executions: %d of %d per %s\n", status.Executions, status.RateLimit, status.RateInterval)
+	} else {
+		fmt.Fprintf(&b, "Executions: unlimited\n")
+	}
+	fmt.Fprintf(&b, "CPU time: %s\n", formatBudget(status.CpuTime, status.CpuLimit, status.ResetAt))
+	fmt.Fprintf(&b, "Wall time: %s\n", formatBudget(status.WallTime, status.WallLimit, status.ResetAt))
+	if !status.ResetAt.IsZero() {
+		fmt.Fprintf(&b, "Window resets at: %s\n", status.ResetAt.Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// formatBudget Describe the used and remaining part of a cumulative quota.
+func formatBudget(used time.Duration, limit time.Duration, resetAt time.Time) string {
+	if limit <= 0 || resetAt.IsZero() {
+		return fmt.Sprintf("%s used, unlimited", used.Round(time.Millisecond))
+	}
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return fmt.Sprintf("%s used of %s, %s remaining", used.Round(time.Millisecond), limit, remaining.Round(time.Millisecond))
+}
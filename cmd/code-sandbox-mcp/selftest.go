@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox/docker"
+	"sort"
+	"strings"
+)
+
+// helloWorldOutput The output expected from every hello-world preset.
+const helloWorldOutput = "Hello, World!"
+
+// helloWorldPresets Hello-world programs of the language presets, keyed by language.
+var helloWorldPresets = map[string]string{
+	"golang": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"Hello, World!\")\n}\n",
+	"php":    "<?php\necho \"Hello, World!\\n\";\n",
+	"python": "print(\"Hello, World!\")\n",
+
+	"javascript": "console.log(\"Hello, World!\");\n",
+	"typescript": "const greeting: string = \"Hello, World!\";\nconsole.log(greeting);\n",
+	"ruby":       "puts \"Hello, World!\"\n",
+	"bash":       "echo \"Hello, World!\"\n",
+	"rust":       "fn main() {\n    println!(\"Hello, World!\");\n}\n",
+	"java":       "public class Main {\n    public static void main(String[] args) {\n        System.out.println(\"Hello, World!\");\n    }\n}\n",
+	"c":          "#include <stdio.h>\n\nint main(void) {\n    printf(\"Hello, World!\\n\");\n    return 0;\n}\n",
+	"cpp":        "#include <iostream>\n\nint main() {\n    std::cout << \"Hello, World!\" << std::endl;\n    return 0;\n}\n",
+}
+
+// runSelfTest Run the hello-world program of every configured language preset against the configured engine.
+func runSelfTest(args []string) int {
+	var config configFlags
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	config.register(flags)
+	only := flags.String("languages", "", "comma separated languages to test, all presets by default")
+	_ = flags.Parse(args)
+
+	configManager, err := config.load()
+	if err != nil {
+		sandbox.InternalLogger.Errorf("Failed to load config: %v", err)
+		return 1
+	}
+	defer configManager.Close()
+
+	var languages []string
+	if *only != "" {
+		languages = strings.Split(*only, ",")
+	} else {
+		for language := range configManager.GetConfig().Languages {
+			languages = append(languages, language)
+		}
+		sort.Strings(languages)
+	}
+
+	artifactCache, err := newArtifactCache(configManager)
+	if err != nil {
+		sandbox.InternalLogger.Errorf("Failed to create compile cache: %v", err)
+		return 1
+	}
+	engine, err := docker.NewClient(context.Background())
+	if err != nil {
+		sandbox.InternalLogger.Errorf("Failed to connect to the Docker engine: %v", err)
+		return 1
+	}
+	defer engine.Close()
+	factory := newSandboxFactory(configManager, artifactCache, engine)
+
+	failed := 0
+	for _, language := range languages {
+		code, ok := helloWorldPresets[language]
+		if !ok {
+			fmt.Printf("SKIP  %-12s no hello-world preset\n", language)
+			continue
+		}
+
+		result, err := runHelloWorld(factory, newSandboxConfig(configManager, language, ""), code)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %-12s %v\n", language, err)
+			continue
+		}
+		fmt.Printf("PASS  %-12s %s\n", language, result.Duration)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d presets failed\n", failed, len(languages))
+		return 1
+	}
+	return 0
+}
+
+// runHelloWorld Execute the hello-world program and check its output.
+func runHelloWorld(factory *sandbox.Factory, config *sandbox.Config, code string) (*sandbox.ExecutionResult, error) {
+	ctx := context.Background()
+	sb, err := factory.Create(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox: %w", err)
+	}
+
+	result, err := sb.Execute(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("exit code %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	if stdout := strings.TrimSpace(result.Stdout); stdout != helloWorldOutput {
+		return nil, fmt.Errorf("unexpected output %q", stdout)
+	}
+	return result, nil
+}
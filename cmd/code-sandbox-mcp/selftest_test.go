@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox/docker"
+	"sort"
+	"testing"
+)
+
+// testConfigPath Config of the repository, relative to the package directory.
+const testConfigPath = "../../config/config.yaml"
+
+// TestHelloWorldPresets Run the hello-world program of every configured language preset against the configured engine,
+// skipped when the Docker daemon is unreachable.
+func TestHelloWorldPresets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs containers, skipped in short mode")
+	}
+
+	configManager, err := sandbox.NewConfigManager(testConfigPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	defer configManager.Close()
+
+	engine, err := docker.NewClient(context.Background())
+	if err != nil {
+		t.Skipf("Docker daemon unreachable: %v", err)
+	}
+	defer engine.Close()
+
+	artifactCache, err := newArtifactCache(configManager)
+	if err != nil {
+		t.Fatalf("failed to create compile cache: %v", err)
+	}
+	factory := newSandboxFactory(configManager, artifactCache, engine)
+
+	languages := make([]string, 0, len(configManager.GetConfig().Languages))
+	for language := range configManager.GetConfig().Languages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	for _, language := range languages {
+		t.Run(language, func(t *testing.T) {
+			code, ok := helloWorldPresets[language]
+			if !ok {
+				t.Skip("no hello-world preset")
+			}
+			result, err := runHelloWorld(factory, newSandboxConfig(configManager, language, ""), code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("%s ran in %s", language, result.Duration)
+		})
+	}
+}
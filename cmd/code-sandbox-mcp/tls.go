@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsReloader Serve the certificate and client CA files, reloading them when they change on disk.
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string // clients must present a certificate signed by this CA if set
+
+	mu       sync.Mutex
+	modTime  time.Time // latest modification time of the files when they were loaded
+	config   *tls.Config
+	checked  time.Time // last time the files were checked for changes
+	interval time.Duration
+}
+
+// newTLSReloader Load the certificate files, an error is returned if they are invalid.
+func newTLSReloader(certFile string, keyFile string, clientCAFile string) (*tlsReloader, error) {
+	r := &tlsReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		interval:     time.Second,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig Return the TLS config of the server, each handshake uses the latest loaded files.
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+// current Return the TLS config, reloading the files if they changed since the last check.
+// The previous config is kept if the new files are invalid.
+func (r *tlsReloader) current() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < r.interval {
+		return r.config
+	}
+	r.checked = time.Now()
+	if modTime, err := r.latestModTime(); err != nil || !modTime.After(r.modTime) {
+		return r.config
+	}
+	if err := r.load(); err != nil {
+		sandbox.InternalLogger.Errorf("Failed to reload TLS certificates, keeping the previous ones: %v", err)
+		return r.config
+	}
+	sandbox.InternalLogger.Infof("Reloaded TLS certificates")
+	return r.config
+}
+
+// reload Load the files.
+func (r *tlsReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checked = time.Now()
+	return r.load()
+}
+
+// load Load the certificate, key and client CA files, the caller must hold mu.
+func (r *tlsReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in client CA %s", r.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.config = config
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime Return the latest modification time of the files.
+func (r *tlsReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
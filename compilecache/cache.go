@@ -0,0 +1,112 @@
+package compilecache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Cache Content-addressed store of compiled artifacts on the local disk.
+// Entries are evicted least recently used first once the size or entry limit is exceeded.
+type Cache struct {
+	dir        string // cache directory
+	maxBytes   int64  // max total size of the entries, 0 means unlimited
+	maxEntries int    // max number of entries, 0 means unlimited
+	mu         sync.Mutex
+}
+
+// NewCache Create a compile cache rooted at dir.
+func NewCache(dir string, maxSizeMb int64, maxEntries int) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{
+		dir:        dir,
+		maxBytes:   maxSizeMb * 1024 * 1024,
+		maxEntries: maxEntries,
+	}, nil
+}
+
+// Get Open the artifact stored under key, the entry is marked as recently used.
+func (c *Cache) Get(key string) (io.ReadCloser, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entryPath := filepath.Join(c.dir, key)
+	file, err := os.Open(entryPath)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(entryPath, now, now)
+	return file, true
+}
+
+// Put Store the artifact read from r under key and evict old entries if the limits are exceeded.
+func (c *Cache) Put(key string, r io.Reader) error {
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, key)); err != nil {
+		return err
+	}
+	return c.evict()
+}
+
+// evict Remove the least recently used entries until the cache fits its limits.
+func (c *Cache) evict() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	var infos []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name()[0] == '.' {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+		total += info.Size()
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	count := len(infos)
+	for _, info := range infos {
+		overSize := c.maxBytes > 0 && total > c.maxBytes
+		overCount := c.maxEntries > 0 && count > c.maxEntries
+		if !overSize && !overCount {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, info.Name())); err != nil {
+			return err
+		}
+		total -= info.Size()
+		count--
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package sandbox
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrEngineUnavailable The sandbox engine is unavailable, the executions fail fast until it recovers.
+var ErrEngineUnavailable = errors.New("sandbox engine unavailable")
+
+// Breaker states
+const (
+	BreakerClosed   = "closed"    // the engine calls go through
+	BreakerOpen     = "open"      // the engine calls fail fast
+	BreakerHalfOpen = "half-open" // a single probe call goes through
+)
+
+// BreakerConfig Thresholds of the circuit breaker.
+type BreakerConfig struct {
+	FailureThreshold int           // consecutive engine failures opening the breaker, 1 if 0
+	OpenTimeout      time.Duration // how long the breaker stays open before a probe call goes through
+}
+
+// Breaker Circuit breaker of the sandbox engine calls.
+// It opens after consecutive engine failures or when the health monitor reports the engine down,
+// and closes once a probe call succeeds or the monitor reports the engine healthy again.
+type Breaker struct {
+	config   BreakerConfig
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool // whether the probe call of the half-open state is running
+	reason   error
+	now      func() time.Time // clock of the open timeout
+}
+
+// NewBreaker Create a closed circuit breaker.
+func NewBreaker(config BreakerConfig) *Breaker {
+	return &Breaker{config: config, state: BreakerClosed, now: time.Now}
+}
+
+// Allow Return ErrEngineUnavailable if the breaker is open.
+// Once the open timeout elapsed, a single probe call is allowed and the others keep failing fast until it reports.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.config.OpenTimeout {
+			return ErrEngineUnavailable
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrEngineUnavailable
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Success Report a successful engine call, closing the breaker.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closeLocked()
+}
+
+// Failure Report a failed engine call, opening the breaker after FailureThreshold consecutive failures
+// or when the probe call failed.
+func (b *Breaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	threshold := b.config.FailureThreshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if b.state == BreakerHalfOpen || b.failures >= threshold {
+		b.openLocked(err)
+	}
+}
+
+// Trip Open the breaker, the health monitor found the engine down.
+func (b *Breaker) Trip(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerOpen {
+		b.openLocked(err)
+	}
+}
+
+// Reset Close the breaker, the health monitor found the engine healthy.
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerClosed {
+		InternalLogger.Infof("Sandbox engine recovered, circuit breaker closed")
+	}
+	b.closeLocked()
+}
+
+// State Return the state of the breaker and why it opened.
+func (b *Breaker) State() (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.reason
+}
+
+// SetConfig Apply new thresholds.
+func (b *Breaker) SetConfig(config BreakerConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.config = config
+}
+
+func (b *Breaker) openLocked(err error) {
+	if b.state != BreakerOpen {
+		InternalLogger.Errorf("Sandbox engine unavailable, circuit breaker opened: %v", err)
+	}
+	b.state = BreakerOpen
+	b.openedAt = b.now()
+	b.probing = false
+	b.reason = err
+}
+
+func (b *Breaker) closeLocked() {
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+	b.reason = nil
+}
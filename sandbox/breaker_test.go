@@ -0,0 +1,120 @@
+package sandbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// breakerStep Call made on the breaker and the state it leaves the breaker in.
+type breakerStep struct {
+	action    string // allow, success, failure, trip, reset or wait
+	wantAllow error  // result of allow
+	wantState string
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	config := BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute}
+	tests := []struct {
+		name  string
+		steps []breakerStep
+	}{
+		{
+			name: "opens after the consecutive failures",
+			steps: []breakerStep{
+				{action: "failure", wantState: BreakerClosed},
+				{action: "success", wantState: BreakerClosed},
+				{action: "failure", wantState: BreakerClosed},
+				{action: "allow", wantState: BreakerClosed},
+				{action: "failure", wantState: BreakerOpen},
+				{action: "allow", wantAllow: ErrEngineUnavailable, wantState: BreakerOpen},
+			},
+		},
+		{
+			name: "probe success closes",
+			steps: []breakerStep{
+				{action: "failure", wantState: BreakerClosed},
+				{action: "failure", wantState: BreakerOpen},
+				{action: "wait", wantState: BreakerOpen},
+				{action: "allow", wantState: BreakerHalfOpen},
+				{action: "allow", wantAllow: ErrEngineUnavailable, wantState: BreakerHalfOpen},
+				{action: "success", wantState: BreakerClosed},
+				{action: "allow", wantState: BreakerClosed},
+				// The failures are counted again from zero.
+				{action: "failure", wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "probe failure reopens",
+			steps: []breakerStep{
+				{action: "failure", wantState: BreakerClosed},
+				{action: "failure", wantState: BreakerOpen},
+				{action: "wait", wantState: BreakerOpen},
+				{action: "allow", wantState: BreakerHalfOpen},
+				{action: "failure", wantState: BreakerOpen},
+				{action: "allow", wantAllow: ErrEngineUnavailable, wantState: BreakerOpen},
+				{action: "wait", wantState: BreakerOpen},
+				{action: "allow", wantState: BreakerHalfOpen},
+			},
+		},
+		{
+			name: "health monitor trips and resets",
+			steps: []breakerStep{
+				{action: "trip", wantState: BreakerOpen},
+				{action: "allow", wantAllow: ErrEngineUnavailable, wantState: BreakerOpen},
+				{action: "reset", wantState: BreakerClosed},
+				{action: "allow", wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "health monitor resets a half-open breaker",
+			steps: []breakerStep{
+				{action: "trip", wantState: BreakerOpen},
+				{action: "wait", wantState: BreakerOpen},
+				{action: "allow", wantState: BreakerHalfOpen},
+				{action: "reset", wantState: BreakerClosed},
+				{action: "allow", wantState: BreakerClosed},
+				{action: "allow", wantState: BreakerClosed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			b := NewBreaker(config)
+			b.now = func() time.Time { return now }
+			engineErr := errors.New("engine down")
+
+			for i, step := range tt.steps {
+				var err error
+				switch step.action {
+				case "allow":
+					err = b.Allow()
+				case "success":
+					b.Success()
+				case "failure":
+					b.Failure(engineErr)
+				case "trip":
+					b.Trip(engineErr)
+				case "reset":
+					b.Reset()
+				case "wait":
+					now = now.Add(config.OpenTimeout)
+				default:
+					t.Fatalf("unknown action %q", step.action)
+				}
+				if !errors.Is(err, step.wantAllow) {
+					t.Fatalf("step %d (%s): err = %v, want %v", i, step.action, err, step.wantAllow)
+				}
+				state, reason := b.State()
+				if state != step.wantState {
+					t.Fatalf("step %d (%s): state = %s, want %s", i, step.action, state, step.wantState)
+				}
+				if state == BreakerOpen && !errors.Is(reason, engineErr) {
+					t.Fatalf("step %d (%s): reason = %v, want %v", i, step.action, reason, engineErr)
+				}
+			}
+		})
+	}
+}
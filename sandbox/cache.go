@@ -0,0 +1,31 @@
+package sandbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// ArtifactCache Cache of compiled artifacts, so re-running identical code skips compilation.
+type ArtifactCache interface {
+	// Get Open the artifact stored under key.
+	Get(key string) (io.ReadCloser, bool)
+
+	// Put Store the artifact read from r under key.
+	Put(key string, r io.Reader) error
+}
+
+// ArtifactKey Return the content address of the compiled artifact,
+// keyed by language, version (the resolved image), source hash, compile flags and dependencies.
+func ArtifactKey(config *Config, code string) string {
+	source := sha256.Sum256([]byte(code))
+	key := sha256.Sum256([]byte(strings.Join([]string{
+		config.Language,
+		config.Image,
+		hex.EncodeToString(source[:]),
+		strings.Join(config.Compile.Entrypoint, " "),
+		strings.Join(config.Dependencies, " "),
+	}, "\x00")))
+	return hex.EncodeToString(key[:])
+}
@@ -1,10 +1,15 @@
 package sandbox
 
 import (
+	"errors"
+	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
-	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,27 +22,192 @@ type SandboxConfig struct {
 
 // languageConfig
 type languageConfig struct {
-	Suffix       string          `yaml:"suffix" mapstructure:"suffix"`
-	DefaultImage string          `yaml:"default_image" mapstructure:"default_image"`
-	BaseImage    string          `yaml:"base_image" mapstructure:"base_image"`
-	Entrypoint   []string        `yaml:"entrypoint" mapstructure:"entrypoint"`
-	Resources    resourcesConfig `yaml:"resources" mapstructure:"resources"`
+	Suffix           string                  `yaml:"suffix" mapstructure:"suffix"`
+	DefaultImage     string                  `yaml:"default_image" mapstructure:"default_image"`
+	Versions         []string                `yaml:"versions" mapstructure:"versions"` // supported versions besides default_image, prefetched by `images pull`
+	BaseImage        string                  `yaml:"base_image" mapstructure:"base_image"`
+	Entrypoint       []string                `yaml:"entrypoint" mapstructure:"entrypoint"` // run entrypoint, used when run is not configured
+	Compile          *phaseConfig            `yaml:"compile" mapstructure:"compile"`
+	Run              *phaseConfig            `yaml:"run" mapstructure:"run"`
+	Dependencies     *dependenciesConfig     `yaml:"dependencies" mapstructure:"dependencies"`
+	CacheVolumes     []volumeConfig          `yaml:"cache_volumes" mapstructure:"cache_volumes"`
+	Prebuilt         *prebuiltConfig         `yaml:"prebuilt" mapstructure:"prebuilt"`
+	PullPolicy       string                  `yaml:"pull_policy" mapstructure:"pull_policy"` // always, if-not-present or never
+	VersionPattern   string                  `yaml:"version_pattern" mapstructure:"version_pattern"`
+	Digests          []digestConfig          `yaml:"digests" mapstructure:"digests"`
+	Resources        resourcesConfig         `yaml:"resources" mapstructure:"resources"` // inherits runtimes.resources
+	VersionOverrides []versionOverrideConfig `yaml:"version_overrides" mapstructure:"version_overrides"`
+}
+
+// versionOverrideConfig
+type versionOverrideConfig struct {
+	Version   string          `yaml:"version" mapstructure:"version"`
+	Resources resourcesConfig `yaml:"resources" mapstructure:"resources"` // inherits the language resources
+}
+
+// phaseConfig
+type phaseConfig struct {
+	Entrypoint []string      `yaml:"entrypoint" mapstructure:"entrypoint"`
+	Timeout    time.Duration `yaml:"timeout" mapstructure:"timeout"`
+	Artifact   string        `yaml:"artifact" mapstructure:"artifact"`
+}
+
+// dependenciesConfig
+type dependenciesConfig struct {
+	Manager   string        `yaml:"manager" mapstructure:"manager"` // pip, npm, go or composer, the syntax of the requested specs
+	Install   []string      `yaml:"install" mapstructure:"install"`
+	Timeout   time.Duration `yaml:"timeout" mapstructure:"timeout"`
+	Allowlist []string      `yaml:"allowlist" mapstructure:"allowlist"`
+}
+
+// prebuiltConfig
+type prebuiltConfig struct {
+	Packages []string `yaml:"packages" mapstructure:"packages"`
+	Install  string   `yaml:"install" mapstructure:"install"`
+	Versions []string `yaml:"versions" mapstructure:"versions"` // versions built by build_images, default_image if empty
+}
+
+// digestConfig
+type digestConfig struct {
+	Version string `yaml:"version" mapstructure:"version"`
+	Digest  string `yaml:"digest" mapstructure:"digest"`
+}
+
+// volumeConfig
+type volumeConfig struct {
+	Name   string `yaml:"name" mapstructure:"name"`
+	Target string `yaml:"target" mapstructure:"target"`
 }
 
 // serverConfig
 type serverConfig struct {
-	Name    string `yaml:"name" mapstructure:"name"`
-	Version string `yaml:"version" mapstructure:"version"`
+	Name            string     `yaml:"name" mapstructure:"name"`
+	Version         string     `yaml:"version" mapstructure:"version"`
+	Address         string     `yaml:"address" mapstructure:"address"`
+	SSEEndpoint     string     `yaml:"sse_endpoint" mapstructure:"sse_endpoint"`
+	MessageEndpoint string     `yaml:"message_endpoint" mapstructure:"message_endpoint"`
+	TLS             tlsConfig  `yaml:"tls" mapstructure:"tls"`
+	Auth            authConfig `yaml:"auth" mapstructure:"auth"`
+}
+
+// tlsConfig
+type tlsConfig struct {
+	CertFile     string `yaml:"cert_file" mapstructure:"cert_file"`
+	KeyFile      string `yaml:"key_file" mapstructure:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file" mapstructure:"client_ca_file"` // clients must present a certificate signed by this CA
+}
+
+// authConfig
+type authConfig struct {
+	Enabled       bool           `yaml:"enabled" mapstructure:"enabled"`
+	Keys          []apiKeyConfig `yaml:"keys" mapstructure:"keys"`
+	KeysFile      string         `yaml:"keys_file" mapstructure:"keys_file"` // "<client> <key>" lines, reloaded on change
+	Policies      []policyConfig `yaml:"policies" mapstructure:"policies"`
+	DefaultPolicy policyConfig   `yaml:"default_policy" mapstructure:"default_policy"` // policy of the clients without one
+}
+
+// policyConfig
+type policyConfig struct {
+	Clients      []string        `yaml:"clients" mapstructure:"clients"`
+	Languages    []string        `yaml:"languages" mapstructure:"languages"`
+	Tools        []string        `yaml:"tools" mapstructure:"tools"`
+	Network      *bool           `yaml:"network" mapstructure:"network"`
+	MaxResources resourcesConfig `yaml:"max_resources" mapstructure:"max_resources"`
+}
+
+// apiKeyConfig
+type apiKeyConfig struct {
+	Client string `yaml:"client" mapstructure:"client"`
+	Key    string `yaml:"key" mapstructure:"key"`
 }
 
 // runtimeConfig
 type runtimeConfig struct {
-	Resources     resourcesConfig `yaml:"resources" mapstructure:"resources"`
-	Network       networkConfig   `yaml:"network" mapstructure:"network"`
-	Engine        string          `yaml:"engine" mapstructure:"engine"`
-	CleanupOnExit bool            `yaml:"cleanup_on_exit" mapstructure:"cleanup_on_exit"`
-	WorkDir       string          `yaml:"work_dir" mapstructure:"work_dir"`
-	Timeout       int64           `yaml:"timeout" mapstructure:"timeout"`
+	Resources     resourcesConfig    `yaml:"resources" mapstructure:"resources"`
+	MaxResources  resourcesConfig    `yaml:"max_resources" mapstructure:"max_resources"` // caps of the resources requested per execution
+	Network       networkConfig      `yaml:"network" mapstructure:"network"`
+	Engine        string             `yaml:"engine" mapstructure:"engine"`
+	CleanupOnExit bool               `yaml:"cleanup_on_exit" mapstructure:"cleanup_on_exit"`
+	WorkDir       string             `yaml:"work_dir" mapstructure:"work_dir"`
+	Timeout       int64              `yaml:"timeout" mapstructure:"timeout"`
+	CompileCache  compileCacheConfig `yaml:"compile_cache" mapstructure:"compile_cache"`
+	Registry      registryConfig     `yaml:"registry" mapstructure:"registry"`
+	Images        imagesConfig       `yaml:"images" mapstructure:"images"`
+	Reaper        reaperConfig       `yaml:"reaper" mapstructure:"reaper"`
+	Health        healthConfig       `yaml:"health" mapstructure:"health"`
+	Retry         retryConfig        `yaml:"retry" mapstructure:"retry"`
+	DrainTimeout  time.Duration      `yaml:"drain_timeout" mapstructure:"drain_timeout"`
+	Concurrency   concurrencyConfig  `yaml:"concurrency" mapstructure:"concurrency"`
+	Quota         quotaConfig        `yaml:"quota" mapstructure:"quota"`
+}
+
+// quotaConfig
+type quotaConfig struct {
+	ClientKey    string        `yaml:"client_key" mapstructure:"client_key"` // api_key, session or ip
+	RateLimit    int           `yaml:"rate_limit" mapstructure:"rate_limit"`
+	RateInterval time.Duration `yaml:"rate_interval" mapstructure:"rate_interval"`
+	Window       time.Duration `yaml:"window" mapstructure:"window"`
+	CpuTime      time.Duration `yaml:"cpu_time" mapstructure:"cpu_time"`
+	WallTime     time.Duration `yaml:"wall_time" mapstructure:"wall_time"`
+}
+
+// concurrencyConfig
+type concurrencyConfig struct {
+	MaxConcurrent int            `yaml:"max_concurrent" mapstructure:"max_concurrent"`
+	Languages     map[string]int `yaml:"languages" mapstructure:"languages"` // max concurrent executions per language
+	QueueSize     int            `yaml:"queue_size" mapstructure:"queue_size"`
+	QueueTimeout  time.Duration  `yaml:"queue_timeout" mapstructure:"queue_timeout"`
+}
+
+// reaperConfig
+type reaperConfig struct {
+	Instance string        `yaml:"instance" mapstructure:"instance"` // name of the server instance, reconciled across restarts
+	Interval time.Duration `yaml:"interval" mapstructure:"interval"`
+	TTL      time.Duration `yaml:"ttl" mapstructure:"ttl"`
+}
+
+// healthConfig
+type healthConfig struct {
+	Interval         time.Duration `yaml:"interval" mapstructure:"interval"`
+	FailureThreshold int           `yaml:"failure_threshold" mapstructure:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout" mapstructure:"open_timeout"`
+}
+
+// retryConfig
+type retryConfig struct {
+	MaxAttempts  int           `yaml:"max_attempts" mapstructure:"max_attempts"`
+	InitialDelay time.Duration `yaml:"initial_delay" mapstructure:"initial_delay"`
+	MaxDelay     time.Duration `yaml:"max_delay" mapstructure:"max_delay"`
+	Multiplier   float64       `yaml:"multiplier" mapstructure:"multiplier"`
+	Jitter       float64       `yaml:"jitter" mapstructure:"jitter"`
+	MaxElapsed   time.Duration `yaml:"max_elapsed" mapstructure:"max_elapsed"`
+}
+
+// imagesConfig
+type imagesConfig struct {
+	AllowedRepositories []string `yaml:"allowed_repositories" mapstructure:"allowed_repositories"`
+}
+
+// registryConfig
+type registryConfig struct {
+	DockerConfig string                     `yaml:"docker_config" mapstructure:"docker_config"`
+	Credentials  []registryCredentialConfig `yaml:"credentials" mapstructure:"credentials"`
+}
+
+// registryCredentialConfig
+type registryCredentialConfig struct {
+	Host          string `yaml:"host" mapstructure:"host"`
+	Username      string `yaml:"username" mapstructure:"username"`
+	Password      string `yaml:"password" mapstructure:"password"`
+	IdentityToken string `yaml:"identity_token" mapstructure:"identity_token"`
+}
+
+// compileCacheConfig
+type compileCacheConfig struct {
+	Enabled    bool   `yaml:"enabled" mapstructure:"enabled"`
+	Dir        string `yaml:"dir" mapstructure:"dir"`
+	MaxSizeMb  int64  `yaml:"max_size_mb" mapstructure:"max_size_mb"`
+	MaxEntries int    `yaml:"max_entries" mapstructure:"max_entries"`
 }
 
 // resourcesConfig
@@ -45,6 +215,7 @@ type resourcesConfig struct {
 	CpuTimeout time.Duration `yaml:"cpu_timeout" mapstructure:"cpu_timeout"`
 	MemoryMb   int64         `yaml:"memory_mb" mapstructure:"memory_mb"`
 	DiskMb     int64         `yaml:"disk_mb" mapstructure:"disk_mb"`
+	Cpus       float64       `yaml:"cpus" mapstructure:"cpus"`
 }
 
 // networkConfig
@@ -53,30 +224,150 @@ type networkConfig struct {
 }
 
 // ConfigManager Config Manager
+// The config is an immutable snapshot swapped atomically on reload, so handlers can read it concurrently.
 type ConfigManager struct {
-	config *SandboxConfig
+	path        string
+	fragments   string // directory of drop-in config fragments merged over the config file
+	config      atomic.Pointer[SandboxConfig]
+	watcher     *fsnotify.Watcher
+	mu          sync.Mutex
+	subscribers []func(old *SandboxConfig, new *SandboxConfig)
 }
 
-// NewConfigManager Create config manager
-func NewConfigManager(configPath ...string) (*ConfigManager, error) {
-	manager := &ConfigManager{}
+// ConfigOption Option of the config manager.
+type ConfigOption func(*ConfigManager)
+
+// WithFragmentsDir Merge the *.yaml fragments of dir over the config file,
+// SANDBOX_CONFIG_DIR or the conf.d directory next to the config file by default.
+func WithFragmentsDir(dir string) ConfigOption {
+	return func(cm *ConfigManager) {
+		if dir != "" {
+			cm.fragments = dir
+		}
+	}
+}
 
-	finalConfigPath := manager.determineConfigPath(configPath...)
-	if err := manager.loadConfig(finalConfigPath); err != nil {
+// NewConfigManager Create config manager
+// The config file is configPath, SANDBOX_CONFIG or one of the default paths.
+func NewConfigManager(configPath string, opts ...ConfigOption) (*ConfigManager, error) {
+	manager := newConfigManager(configPath, opts...)
+	config, err := manager.loadConfig()
+	if err != nil {
 		return nil, err
 	}
+	manager.config.Store(config)
 
-	// watch config
-	viper.WatchConfig()
-	viper.OnConfigChange(func(in fsnotify.Event) {
-		if err := manager.loadConfig(finalConfigPath); err != nil {
-			log.Fatalf("Failed to reload config after change: %v", err)
+	// watch the directory, editors replace the file instead of writing it
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch config: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(manager.path)); err != nil {
+		_ = watcher.Close()
+		return nil, fmt.Errorf("failed to watch config: %w", err)
+	}
+	if info, err := os.Stat(manager.fragments); err == nil && info.IsDir() {
+		if err := watcher.Add(manager.fragments); err != nil {
+			_ = watcher.Close()
+			return nil, fmt.Errorf("failed to watch config fragments: %w", err)
 		}
-	})
+	}
+	manager.watcher = watcher
+	go manager.watch()
 
 	return manager, nil
 }
 
+// newConfigManager Resolve the config file and fragments directory.
+func newConfigManager(configPath string, opts ...ConfigOption) *ConfigManager {
+	manager := &ConfigManager{}
+	if configPath == "" {
+		configPath = os.Getenv(envConfig)
+	}
+	manager.path = manager.determineConfigPath(configPath)
+	manager.fragments = os.Getenv(envConfigDir)
+	if manager.fragments == "" {
+		manager.fragments = filepath.Join(filepath.Dir(manager.path), "conf.d")
+	}
+	for _, opt := range opts {
+		opt(manager)
+	}
+	return manager
+}
+
+// Path Return the config file.
+func (cm *ConfigManager) Path() string {
+	return cm.path
+}
+
+// Close Stop watching the config file.
+func (cm *ConfigManager) Close() error {
+	return cm.watcher.Close()
+}
+
+// Subscribe Register fn to be called with the previous and the new config after every successful reload.
+func (cm *ConfigManager) Subscribe(fn func(old *SandboxConfig, new *SandboxConfig)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.subscribers = append(cm.subscribers, fn)
+}
+
+// Reload Load and validate the config file, then swap it in and notify the subscribers.
+// The current config is kept if the file is invalid.
+func (cm *ConfigManager) Reload() error {
+	config, err := cm.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	old := cm.config.Load()
+	if reflect.DeepEqual(old, config) {
+		return nil
+	}
+	cm.config.Store(config)
+	for _, fn := range cm.subscribers {
+		fn(old, config)
+	}
+	return nil
+}
+
+// watch Reload the config when the file changes.
+func (cm *ConfigManager) watch() {
+	for {
+		select {
+		case event, ok := <-cm.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 || !cm.isConfigFile(event.Name) {
+				continue
+			}
+			if err := cm.Reload(); err != nil {
+				InternalLogger.Errorf("Failed to reload config %s, keeping the previous config: %v", cm.path, err)
+				continue
+			}
+			InternalLogger.Infof("Reloaded config %s", cm.path)
+		case err, ok := <-cm.watcher.Errors:
+			if !ok {
+				return
+			}
+			InternalLogger.Errorf("Failed to watch config: %v", err)
+		}
+	}
+}
+
+// isConfigFile Whether the file is the config file or one of its fragments.
+func (cm *ConfigManager) isConfigFile(path string) bool {
+	if filepath.Clean(path) == filepath.Clean(cm.path) {
+		return true
+	}
+	return filepath.Dir(filepath.Clean(path)) == filepath.Clean(cm.fragments) && isFragment(path)
+}
+
 // determineConfigPath
 func (cm *ConfigManager) determineConfigPath(configPaths ...string) string {
 	for _, path := range configPaths {
@@ -103,38 +394,76 @@ func (cm *ConfigManager) determineConfigPath(configPaths ...string) string {
 	return "./config.yaml"
 }
 
-func (cm *ConfigManager) loadConfig(configPath string) error {
-	viper.SetConfigFile(configPath)
+// loadConfig Parse and validate the config into a new snapshot.
+func (cm *ConfigManager) loadConfig() (*SandboxConfig, error) {
+	config, problems := cm.parseConfig()
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid config %s: %w", cm.path, errors.Join(problems...))
+	}
+	return config, nil
+}
+
+// CheckConfig Parse and validate the config, returning the config file and every problem found.
+func CheckConfig(configPath string, opts ...ConfigOption) (string, []error) {
+	manager := newConfigManager(configPath, opts...)
+	_, problems := manager.parseConfig()
+	return manager.path, problems
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		return err
+// parseConfig Parse the config file merged with its fragments and the env overrides,
+// rejecting unknown keys and invalid values, then validate it.
+// The decoding goes on after a problem so all of them are reported.
+func (cm *ConfigManager) parseConfig() (*SandboxConfig, []error) {
+	v := viper.New()
+	v.SetConfigFile(cm.path)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, []error{err}
+	}
+	if err := mergeFragments(v, cm.fragments); err != nil {
+		return nil, []error{err}
 	}
+	applyEnvOverrides(v, os.Environ())
 
 	var config SandboxConfig
-	if err := viper.Unmarshal(&config); err != nil {
-		return err
-	}
+	problems := flattenErrors(v.UnmarshalExact(&config))
+	problems = append(problems, flattenErrors(config.Validate())...)
+	return &config, problems
+}
 
-	cm.config = &config
-	return nil
+// flattenErrors Return the leaf errors of joined errors, the messages wrapping joined errors are dropped.
+func flattenErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		return []error{err}
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(e)...)
+	}
+	return errs
 }
 
+// GetConfig Return the current config snapshot, it must not be modified.
 func (cm *ConfigManager) GetConfig() *SandboxConfig {
-	return cm.config
+	return cm.config.Load()
 }
 
 func (cm *ConfigManager) GetRuntimesConfig() runtimeConfig {
-	return cm.config.Runtimes
+	return cm.config.Load().Runtimes
 }
 
 func (cm *ConfigManager) GetRuntimeEngine() string {
-	return cm.config.Runtimes.Engine
+	return cm.config.Load().Runtimes.Engine
 }
 
 func (cm *ConfigManager) GetServerConfig() serverConfig {
-	return cm.config.Server
+	return cm.config.Load().Server
 }
 
 func (cm *ConfigManager) GetLanguageConfig(language string) languageConfig {
-	return cm.config.Languages[language]
+	return cm.config.Load().Languages[language]
 }
@@ -0,0 +1,142 @@
+package sandbox
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Package managers installing the dependencies, the spec syntax of each is parsed strictly
+// so that only a registry package name with a version constraint reaches the install command.
+const (
+	ManagerPip      = "pip"
+	ManagerNpm      = "npm"
+	ManagerGo       = "go"
+	ManagerComposer = "composer"
+)
+
+// Managers Supported package managers.
+var Managers = []string{ManagerPip, ManagerNpm, ManagerGo, ManagerComposer}
+
+var (
+	// pipSpecPattern `name[extras]` followed by comma separated version clauses, such as `requests>=2,<3`.
+	// URLs (`name @ https://...`), markers (`; python_version...`) and paths are not matched.
+	pipSpecPattern    = regexp.MustCompile(`^([A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9])?)(\[[A-Za-z0-9._,-]+\])?(.*)$`)
+	pipVersionPattern = regexp.MustCompile(`^(===|==|~=|!=|>=|<=|>|<)[0-9][0-9A-Za-z.+!*-]*$`)
+
+	// npmNamePattern `name` or `@scope/name`.
+	npmNamePattern = regexp.MustCompile(`^(@[a-z0-9][a-z0-9._-]*/)?[a-z0-9][a-z0-9._-]*$`)
+	// npmVersionPattern A semver version or range without spaces, such as `4.17.21`, `^1.6` or `>=2.0.0`, or a dist-tag.
+	// Git, GitHub, URL, file and alias specs contain `:`, `/` or `@` and are not matched.
+	npmVersionPattern = regexp.MustCompile(`^((~|\^|[<>]=?|=)?v?[0-9]+(\.([0-9]+|x|\*)){0,2}(-[0-9A-Za-z.-]+)?|[A-Za-z][A-Za-z0-9._-]*)$`)
+
+	// goModulePattern Module path, its first element is a domain, such as `github.com/google/uuid`.
+	goModulePattern = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)+(/[A-Za-z0-9._~-]+)*$`)
+	// goVersionPattern A semantic version, possibly a prefix of one such as `v1`, or `latest`.
+	goVersionPattern = regexp.MustCompile(`^(latest|v[0-9]+(\.[0-9]+){0,2}(-[0-9A-Za-z.-]+)?(\+incompatible)?)$`)
+
+	// composerNamePattern `vendor/package`.
+	composerNamePattern = regexp.MustCompile(`^[a-z0-9]([_.-]?[a-z0-9]+)*/[a-z0-9](([_.]|-{1,2})?[a-z0-9]+)*$`)
+	// composerVersionPattern A version constraint without spaces, such as `^3.0`, `~7.8.1` or `>=2.0`.
+	// Branches (`dev-main`), VCS references (`#commit`) and stability flags are not matched.
+	composerVersionPattern = regexp.MustCompile(`^(~|\^|[<>]=?|!=|=)?v?[0-9]+(\.([0-9]+|x|\*)){0,3}(-(alpha|beta|RC|rc)[0-9.]*)?$`)
+)
+
+// ParseDependency Return the package name of a dependency spec of the package manager,
+// an error is returned unless the spec is a registry package with an optional version constraint.
+func ParseDependency(manager string, spec string) (string, error) {
+	switch manager {
+	case ManagerPip:
+		return parsePipDependency(spec)
+	case ManagerNpm:
+		return parseNpmDependency(spec)
+	case ManagerGo:
+		return parseGoDependency(spec)
+	case ManagerComposer:
+		return parseComposerDependency(spec)
+	default:
+		return "", fmt.Errorf("unsupported package manager %q", manager)
+	}
+}
+
+// parsePipDependency Parse a PEP 508 requirement restricted to a name, extras and version clauses.
+func parsePipDependency(spec string) (string, error) {
+	match := pipSpecPattern.FindStringSubmatch(spec)
+	if match == nil {
+		return "", fmt.Errorf("invalid dependency %q", spec)
+	}
+	if match[3] != "" {
+		for _, clause := range strings.Split(match[3], ",") {
+			if !pipVersionPattern.MatchString(clause) {
+				return "", fmt.Errorf("invalid version constraint %q of dependency %q", clause, spec)
+			}
+		}
+	}
+	return match[1], nil
+}
+
+// parseNpmDependency Parse `name@version`, a leading @ is the scope of the name.
+func parseNpmDependency(spec string) (string, error) {
+	name, version := spec, ""
+	if i := strings.LastIndex(spec, "@"); i > 0 {
+		name, version = spec[:i], spec[i+1:]
+		if version == "" {
+			return "", fmt.Errorf("invalid dependency %q", spec)
+		}
+	}
+	if !npmNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid dependency %q", spec)
+	}
+	if version != "" && !npmVersionPattern.MatchString(version) {
+		return "", fmt.Errorf("invalid version %q of dependency %q", version, spec)
+	}
+	return name, nil
+}
+
+// parseGoDependency Parse `module@version`.
+func parseGoDependency(spec string) (string, error) {
+	name, version, hasVersion := strings.Cut(spec, "@")
+	if !goModulePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid dependency %q", spec)
+	}
+	if hasVersion && !goVersionPattern.MatchString(version) {
+		return "", fmt.Errorf("invalid version %q of dependency %q", version, spec)
+	}
+	return name, nil
+}
+
+// parseComposerDependency Parse `vendor/package:constraint`.
+func parseComposerDependency(spec string) (string, error) {
+	name, version, hasVersion := strings.Cut(spec, ":")
+	if !composerNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid dependency %q", spec)
+	}
+	if hasVersion && !composerVersionPattern.MatchString(version) {
+		return "", fmt.Errorf("invalid version constraint %q of dependency %q", version, spec)
+	}
+	return name, nil
+}
+
+// ValidateDependencies Check the requested dependencies against the allowlist of installable packages.
+// Allowlist entries are package names and may contain glob patterns, such as `github.com/google/*`.
+func ValidateDependencies(manager string, allowlist []string, dependencies []string) error {
+	for _, spec := range dependencies {
+		name, err := ParseDependency(manager, spec)
+		if err != nil {
+			return err
+		}
+
+		allowed := false
+		for _, pattern := range allowlist {
+			if ok, _ := path.Match(pattern, name); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("dependency %q is not in the allowlist", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"io"
+	"text/template"
+	"time"
+)
+
+const (
+	// prebuiltRepository Repository of the derived images built by the server.
+	prebuiltRepository = "mcp-sandbox"
+	// labelLanguage Label holding the language of the derived image.
+	labelLanguage = "mcp-sandbox.language"
+	// labelBaseImage Label holding the base image of the derived image.
+	labelBaseImage = "mcp-sandbox.base-image"
+)
+
+type PrebuiltTmpl struct {
+	Packages string `json:"packages"`
+}
+
+// derivedImage Return the deterministic tag and the Dockerfile of the image derived from the base image,
+// the tag is the content hash of the Dockerfile so a change of the base image or packages yields a new image.
+func derivedImage(language string, baseImage string, prebuilt *sandbox.PrebuiltConfig) (string, string, error) {
+	tmpl, err := template.New("prebuilt").Parse(prebuilt.Install)
+	if err != nil {
+		return "", "", err
+	}
+	var run bytes.Buffer
+	if err := tmpl.Execute(&run, PrebuiltTmpl{Packages: quotePackages(prebuilt.Packages)}); err != nil {
+		return "", "", err
+	}
+
+	dockerfile := fmt.Sprintf("FROM %s\nRUN %s\n", baseImage, run.String())
+	sum := sha256.Sum256([]byte(dockerfile))
+	tag := fmt.Sprintf("%s/%s:%s", prebuiltRepository, language, hex.EncodeToString(sum[:])[:16])
+	return tag, dockerfile, nil
+}
+
+// buildImage Build the derived image via the Docker build API.
+// The credentials of the base image registry are passed to the build, pullParent pulls the base image even if it is present.
+func buildImage(ctx context.Context, cli *client.Client, resolver *RegistryAuthResolver, pullParent bool, language string, baseImage string, tag string, dockerfile string, out io.Writer) error {
+	authConfigs := map[string]registry.AuthConfig{}
+	host, authConfig, ok, err := resolver.AuthConfig(baseImage)
+	if err != nil {
+		return err
+	}
+	if ok {
+		authConfigs[host] = authConfig
+	}
+
+	var buildContext bytes.Buffer
+	tw := tar.NewWriter(&buildContext)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    "Dockerfile",
+		Mode:    0644,
+		Size:    int64(len(dockerfile)),
+		ModTime: time.Unix(0, 0),
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write([]byte(dockerfile)); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	resp, err := cli.ImageBuild(ctx, &buildContext, build.ImageBuildOptions{
+		Tags:        []string{tag},
+		Dockerfile:  "Dockerfile",
+		Remove:      true,
+		ForceRemove: true,
+		PullParent:  pullParent,
+		AuthConfigs: authConfigs,
+		Labels: map[string]string{
+			labelLanguage:  language,
+			labelBaseImage: baseImage,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build image %s: %w", tag, err)
+	}
+	defer func(body io.ReadCloser) {
+		err := body.Close()
+		if err != nil {
+			sandbox.InternalLogger.Errorf("failed to close build image: %s", err.Error())
+		}
+	}(resp.Body)
+
+	// The stream reports build errors as messages, they are returned as a JSONError.
+	if err := jsonmessage.DisplayJSONMessagesStream(resp.Body, out, 0, false, nil); err != nil {
+		return fmt.Errorf("failed to build image %s: %w", tag, err)
+	}
+	return nil
+}
+
+// BuildPrebuiltImage Build the derived image of the language version, skipping it if it already exists.
+// Return the tag of the derived image.
+func BuildPrebuiltImage(ctx context.Context, config *sandbox.Config, resolver *RegistryAuthResolver, force bool, out io.Writer) (string, error) {
+	if config.Prebuilt == nil {
+		return "", fmt.Errorf("language %s has no prebuilt image", config.Language)
+	}
+	cli, err := newClient()
+	if err != nil {
+		return "", err
+	}
+	defer cli.Close()
+
+	if config.Version == "" {
+		config.Version = config.BaseImage
+	}
+	baseImage, err := getRuntimeImage(ctx, config)
+	if err != nil {
+		return "", fmt.Errorf("failed to get image: %w", err)
+	}
+	tag, dockerfile, err := derivedImage(config.Language, baseImage, config.Prebuilt)
+	if err != nil {
+		return "", fmt.Errorf("failed to render prebuilt image: %w", err)
+	}
+
+	if !force {
+		if _, err := cli.ImageInspect(ctx, tag); err == nil {
+			return tag, nil
+		}
+	}
+	return tag, buildImage(ctx, cli, resolver, config.PullPolicy == sandbox.PullAlways, config.Language, baseImage, tag, dockerfile, out)
+}
@@ -0,0 +1,80 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/client"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"sync"
+	"time"
+)
+
+// pingTimeout Max duration of a ping of the daemon.
+const pingTimeout = 5 * time.Second
+
+// Client Docker engine client shared by the sandboxes and the reaper of the server.
+// The connection is re-established when a health check fails, e.g. after the daemon restarted.
+type Client struct {
+	mu  sync.RWMutex
+	cli *client.Client
+}
+
+// NewClient Connect to the Docker engine from the environment, an error is returned if the daemon is unreachable.
+func NewClient(ctx context.Context) (*Client, error) {
+	cli, err := connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{cli: cli}, nil
+}
+
+// API Return the current connection, callers must not close it.
+func (c *Client) API() *client.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cli
+}
+
+// Check Ping the daemon, reconnecting if it does not answer.
+func (c *Client) Check(ctx context.Context) error {
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	_, err := c.API().Ping(pingCtx)
+	cancel()
+	if err == nil {
+		return nil
+	}
+	sandbox.InternalLogger.Warnf("Docker daemon did not answer the ping, reconnecting: %v", err)
+
+	cli, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	previous := c.cli
+	c.cli = cli
+	c.mu.Unlock()
+	// In-flight requests of the sandboxes still holding the previous connection are not interrupted.
+	_ = previous.Close()
+	sandbox.InternalLogger.Infof("Reconnected to the Docker daemon")
+	return nil
+}
+
+// Close Close the connection.
+func (c *Client) Close() error {
+	return c.API().Close()
+}
+
+// connect Create a client from the environment and ping the daemon.
+func connect(ctx context.Context) (*client.Client, error) {
+	cli, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if _, err := cli.Ping(pingCtx); err != nil {
+		_ = cli.Close()
+		return nil, fmt.Errorf("failed to reach the Docker daemon: %w", err)
+	}
+	return cli, nil
+}
@@ -3,13 +3,14 @@ package docker
 import (
 	"context"
 	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"time"
 )
 
 // NewDockerSandboxCreator Return a function that can create a new DockerSandbox instance.
 // This function itself does not create an instance but returns a function that creates an instance.
-func NewDockerSandboxCreator() func(ctx context.Context, config *sandbox.Config) (sandbox.Sandbox, error) {
+func NewDockerSandboxCreator(opts ...CreatorOption) func(ctx context.Context, config *sandbox.Config) (sandbox.Sandbox, error) {
 	return func(ctx context.Context, config *sandbox.Config) (sandbox.Sandbox, error) {
-		ds, err := NewDockerSandbox(ctx, config)
+		ds, err := NewDockerSandbox(ctx, config, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -17,3 +18,52 @@ func NewDockerSandboxCreator() func(ctx context.Context, config *sandbox.Config)
 		return ds, nil
 	}
 }
+
+// CreatorOption Configure the DockerSandbox instances created by the creator.
+type CreatorOption func(*DockerSandbox)
+
+// WithClient Run the sandboxes with the shared engine client.
+func WithClient(engine *Client) CreatorOption {
+	return func(ds *DockerSandbox) {
+		ds.client = engine.API()
+	}
+}
+
+// WithRetryPolicy Retry the create, start, pull and remove operations with the policy,
+// the Docker errors are classified by IsRetryable unless the policy has its own classifier.
+func WithRetryPolicy(policy sandbox.RetryPolicy) CreatorOption {
+	return func(ds *DockerSandbox) {
+		ds.retry = policy
+	}
+}
+
+// WithRegistryAuth Pull images with the credentials resolved by resolver.
+func WithRegistryAuth(resolver *RegistryAuthResolver) CreatorOption {
+	return func(ds *DockerSandbox) {
+		ds.registryAuth = resolver
+	}
+}
+
+// WithPullRecord Record the pulled images, so that `images prune -base` can remove them.
+func WithPullRecord(record *PullRecord) CreatorOption {
+	return func(ds *DockerSandbox) {
+		ds.pullRecord = record
+	}
+}
+
+// WithInstance Label the containers with the server instance, its configured name and their ttl,
+// so the Reaper can remove leaked ones.
+func WithInstance(instanceID string, name string, ttl time.Duration) CreatorOption {
+	return func(ds *DockerSandbox) {
+		ds.instanceID = instanceID
+		ds.instanceName = name
+		ds.ttl = ttl
+	}
+}
+
+// WithArtifactCache Reuse compiled artifacts across executions.
+func WithArtifactCache(cache sandbox.ArtifactCache) CreatorOption {
+	return func(ds *DockerSandbox) {
+		ds.artifactCache = cache
+	}
+}
@@ -1,68 +1,97 @@
 package docker
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	cerrdefs "github.com/containerd/errdefs"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/google/uuid"
 	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
 	"github.com/lemonlyue/code-sandbox-mcp/tempfile"
 	"io"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// networkNone Network mode of the containers without network access.
+	networkNone = "none"
+	// cleanupTimeout Least time given to the cleanup, even if the execution deadline already expired.
+	cleanupTimeout = 30 * time.Second
+)
+
 // DockerSandbox It is the Docker implementation of the Sandbox interface.
 type DockerSandbox struct {
-	client      *client.Client
-	config      *sandbox.Config
-	containerID string
-	mu          sync.Mutex
-	cleaned     bool
+	client        *client.Client
+	config        *sandbox.Config
+	containerID   string
+	baseImage     string // image the sandbox image is derived from, the same as the config image without prebuilt packages
+	dockerfile    string // Dockerfile of the derived image
+	registryAuth  *RegistryAuthResolver
+	pullRecord    *PullRecord               // records the pulled images, nil if not recorded
+	fileManager   *tempfile.TempFileManager // temp files of the code, removed on cleanup
+	workVolume    string                    // work directory shared with the install container, removed on cleanup
+	instanceID    string                    // ID of the server instance owning the container
+	instanceName  string                    // configured name of the server instance, empty if not configured
+	ttl           time.Duration             // the container is reaped once it outlives the ttl
+	artifactCache sandbox.ArtifactCache
+	retry         sandbox.RetryPolicy // retry of the engine operations
+	mu            sync.Mutex
+	cleaned       bool
 }
 
 // NewDockerSandbox
 // receive the common SandboxConfig and convert it to a Docker-specific configuration
-func NewDockerSandbox(ctx context.Context, config *sandbox.Config) (sandbox.Sandbox, error) {
-	sandbox.InternalLogger.Ctx(ctx).Infof("Creating Docker client")
-	cli, err := client.NewClientWithOpts(
-		client.FromEnv,
-		client.WithAPIVersionNegotiation(),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+// The sandbox uses the shared client set by WithClient.
+func NewDockerSandbox(ctx context.Context, config *sandbox.Config, opts ...CreatorOption) (sandbox.Sandbox, error) {
+	ds := &DockerSandbox{
+		config: config,
+		retry:  sandbox.DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(ds)
+	}
+	if ds.client == nil {
+		return nil, fmt.Errorf("no Docker client provided")
+	}
+	if ds.retry.Retryable == nil {
+		ds.retry.Retryable = IsRetryable
 	}
-	defer func(cli *client.Client) {
-		err := cli.Close()
-		if err != nil {
-			sandbox.InternalLogger.Errorf("failed to close docker: %s", err.Error())
-		}
-	}(cli)
-	sandbox.InternalLogger.Ctx(ctx).Infof("Docker client created successfully")
 
 	if config.Language != "" && config.Version == "" {
 		config.Version = config.BaseImage
 	}
 	// get runtime image
+	var err error
 	config.Image, err = getRuntimeImage(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get image: %w", err)
 	}
 
-	// Construct docker
-	return &DockerSandbox{
-		client: cli,
-		config: config,
-	}, nil
+	// Run the derived image with the prebuilt packages instead of the base image.
+	baseImage := config.Image
+	var dockerfile string
+	if config.Prebuilt != nil {
+		config.Image, dockerfile, err = derivedImage(config.Language, baseImage, config.Prebuilt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render prebuilt image: %w", err)
+		}
+	}
+
+	ds.baseImage = baseImage
+	ds.dockerfile = dockerfile
+	return ds, nil
 }
 
 // Execute execute code
+// The whole execution, from the image pull to the cleanup, is bounded by the config timeout.
 func (ds *DockerSandbox) Execute(ctx context.Context, code string) (*sandbox.ExecutionResult, error) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -70,17 +99,40 @@ func (ds *DockerSandbox) Execute(ctx context.Context, code string) (*sandbox.Exe
 		}
 	}()
 	start := time.Now()
+	result := &sandbox.ExecutionResult{}
+	if ds.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ds.config.Timeout)
+		defer cancel()
+	}
 
 	// Pull image.
-	err := ds.ensureImage(ctx)
+	err := ds.phase(ctx, result, sandbox.PhasePull, func() error {
+		return ds.ensureImage(ctx)
+	})
 	if err != nil {
-		return nil, err
+		return ds.failed(result, err)
 	}
 
 	fileManager, err := tempfile.NewTempFileManager("/var/tmp/")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file manager: %w", err)
 	}
+	ds.mu.Lock()
+	ds.fileManager = fileManager
+	ds.mu.Unlock()
+	// Release the container and the temp files on every return path,
+	// even if the request context is already cancelled or the deadline expired.
+	defer func() {
+		cleanupCtx, cancel := ds.cleanupContext(ctx)
+		defer cancel()
+		cleanupStart := time.Now()
+		err := ds.Cleanup(cleanupCtx)
+		result.Timings = append(result.Timings, sandbox.PhaseTiming{Phase: sandbox.PhaseCleanup, Duration: time.Since(cleanupStart)})
+		if err != nil {
+			sandbox.InternalLogger.Errorf("failed to clean up: %s", err.Error())
+		}
+	}()
 	path := fileManager.GetDir()
 	fileName := "main." + ds.config.Suffix
 	hostPath, err := fileManager.WriteFile(fileName, []byte(code), 0644)
@@ -103,6 +155,7 @@ func (ds *DockerSandbox) Execute(ctx context.Context, code string) (*sandbox.Exe
 		WithCommand([]string{
 			"tail", "-f", "/dev/null",
 		}...),
+		WithLabels(sandboxLabels(ds.instanceID, ds.instanceName, time.Now(), ds.ttl)),
 	)
 
 	// host config
@@ -110,42 +163,367 @@ func (ds *DockerSandbox) Execute(ctx context.Context, code string) (*sandbox.Exe
 		hostCfg,
 		WithAutoRemove(false),
 		WithBindMount(hostPath, hostPath),
-		WithDiskMb(path, ds.config.Resource.DiskMb),
 	)
+	// The dependencies are installed in the work directory by the install container,
+	// the code only reads the package caches it populated.
+	if ds.installsDependencies() {
+		WithOptions(hostCfg, WithVolumeMount(containerName+"_work", path, false))
+		for _, volume := range ds.config.CacheVolumes {
+			WithOptions(hostCfg, WithVolumeMount(volume.Name, volume.Target, true))
+		}
+	} else {
+		WithOptions(hostCfg, WithDiskMb(path, ds.config.Resource.DiskMb))
+	}
+	if !ds.networkEnabled() {
+		WithOptions(hostCfg, WithNetworkMode(networkNone))
+	}
 
 	// resource config
 	WithOptions(
 		resourcesCfg,
 		WithMemory(ds.config.Resource.MemoryMb),
-		WithCpuTimeout(ds.config.Resource.CpuTimeout),
+		WithCpus(ds.config.Resource.Cpus),
 	)
+	WithOptions(hostCfg, WithResources(resourcesCfg))
 	sandbox.InternalLogger.Infof("the container configuration was successfully")
 
-	resp, err := ds.client.ContainerCreate(ctx, containerCfg, hostCfg, nil, nil, containerName)
+	err = ds.phase(ctx, result, sandbox.PhaseCreate, func() error {
+		if ds.installsDependencies() {
+			if err := ds.createWorkVolume(ctx, containerName+"_work"); err != nil {
+				return err
+			}
+		}
+		id, err := ds.createContainer(ctx, containerCfg, hostCfg, containerName)
+		if err != nil {
+			return err
+		}
+		ds.mu.Lock()
+		ds.containerID = id
+		ds.mu.Unlock()
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create container: %w", err)
+		return ds.failed(result, err)
 	}
 	sandbox.InternalLogger.Infof("Create container successfully")
-	ds.containerID = resp.ID
 
-	// Start the container
-	err = ds.client.ContainerStart(ctx, ds.containerID, container.StartOptions{})
+	// Start the container, it keeps the work volume mounted while the install container fills it.
+	err = ds.phase(ctx, result, sandbox.PhaseStart, func() error {
+		return ds.startContainer(ctx, ds.containerID)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to start container: %w", err)
+		return ds.failed(result, err)
 	}
 
-	sandbox.InternalLogger.Infof("Build execution command successfully")
+	tmplData := EntrypointTmpl{
+		ExecFile: hostPath,
+		Path:     path,
+		Packages: quotePackages(ds.config.Dependencies),
+	}
+
+	// Install phase, skip the compile and run phases if it fails.
+	if ds.installsDependencies() {
+		err = ds.phase(ctx, result, sandbox.PhaseInstall, func() error {
+			install, err := ds.install(ctx, containerName+"_install", hostPath, resourcesCfg, tmplData)
+			if err != nil {
+				return fmt.Errorf("failed to install dependencies: %w", err)
+			}
+			result.Install = install
+			sandbox.InternalLogger.Infof("Install dependencies finished in %s with exit code %d", result.Install.Duration, result.Install.ExitCode)
+			return nil
+		})
+		if err != nil {
+			return ds.failed(result, err)
+		}
+	}
+
+	// Compile phase, skip the run phase if it fails.
+	if ds.config.Compile != nil && !result.InstallFailed() {
+		err = ds.phase(ctx, result, sandbox.PhaseCompile, func() error {
+			compile, err := ds.compile(ctx, code, tmplData)
+			if err != nil {
+				return fmt.Errorf("failed to compile: %w", err)
+			}
+			result.Compile = compile
+			sandbox.InternalLogger.Infof("Compile finished in %s with exit code %d", result.Compile.Duration, result.Compile.ExitCode)
+			return nil
+		})
+		if err != nil {
+			return ds.failed(result, err)
+		}
+	}
+
+	switch {
+	case result.InstallFailed():
+		result.ExitCode = result.Install.ExitCode
+	case result.CompileFailed():
+		result.ExitCode = result.Compile.ExitCode
+	default:
+		err = ds.phase(ctx, result, sandbox.PhaseRun, func() error {
+			run, err := ds.execPhase(ctx, ds.containerID, ds.config.Run, tmplData)
+			if err != nil {
+				return fmt.Errorf("failed to run: %w", err)
+			}
+			result.Stdout = run.Stdout
+			result.Stderr = run.Stderr
+			result.ExitCode = run.ExitCode
+			return nil
+		})
+		if err != nil {
+			return ds.failed(result, err)
+		}
+	}
+	result.Duration = time.Since(start)
+	result.CpuTime = ds.cpuTime(ctx)
+
+	return result, nil
+}
+
+// phase Run fn as the named phase and record its duration.
+// The error is a *sandbox.DeadlineError naming the phase if the execution deadline expired during it.
+func (ds *DockerSandbox) phase(ctx context.Context, result *sandbox.ExecutionResult, name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	result.Timings = append(result.Timings, sandbox.PhaseTiming{Phase: name, Duration: time.Since(start)})
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		sandbox.InternalLogger.Warnf("Execution deadline of %s expired during the %s phase: %v", ds.config.Timeout, name, err)
+		return &sandbox.DeadlineError{Phase: name, Timeout: ds.config.Timeout}
+	}
+	return err
+}
+
+// failed Return the error of a phase, with the partial result holding the timings if the deadline expired.
+func (ds *DockerSandbox) failed(result *sandbox.ExecutionResult, err error) (*sandbox.ExecutionResult, error) {
+	var deadlineErr *sandbox.DeadlineError
+	if errors.As(err, &deadlineErr) {
+		return result, err
+	}
+	return nil, err
+}
+
+// cleanupContext Return the context of the cleanup, detached from the request so the container is removed
+// even after the deadline expired, and bounded by the remaining deadline or cleanupTimeout, whichever is longer.
+func (ds *DockerSandbox) cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := cleanupTimeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) > timeout {
+		timeout = time.Until(deadline)
+	}
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
+
+// networkEnabled Whether the code may access the network.
+func (ds *DockerSandbox) networkEnabled() bool {
+	return ds.config.NetWork != nil && ds.config.NetWork.Enabled
+}
+
+// createContainer Create a container, return its ID.
+// A retry adopts the container created by a previous attempt whose response was lost, found by its name.
+// The name is unique to the execution, so a conflict on it is not retried.
+func (ds *DockerSandbox) createContainer(ctx context.Context, containerCfg *container.Config, hostCfg *container.HostConfig, name string) (string, error) {
+	policy := ds.retry
+	retryable := policy.Retryable
+	policy.Retryable = func(err error) bool {
+		return !cerrdefs.IsConflict(err) && (retryable == nil || retryable(err))
+	}
+
+	var id string
+	attempt := 0
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		attempt++
+		if attempt > 1 {
+			if existing, err := ds.client.ContainerInspect(ctx, name); err == nil {
+				sandbox.InternalLogger.Warnf("Adopting container %s created by a previous attempt", name)
+				id = existing.ID
+				return nil
+			}
+		}
+		resp, err := ds.client.ContainerCreate(ctx, containerCfg, hostCfg, nil, nil, name)
+		if err != nil {
+			return fmt.Errorf("failed to create container: %w", err)
+		}
+		id = resp.ID
+		return nil
+	})
+	return id, err
+}
+
+// startContainer Start a created container.
+func (ds *DockerSandbox) startContainer(ctx context.Context, id string) error {
+	return ds.retry.Do(ctx, func(ctx context.Context) error {
+		if err := ds.client.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+			return fmt.Errorf("failed to start container: %w", err)
+		}
+		return nil
+	})
+}
+
+// removeContainer Force remove a container with its anonymous volumes, a container already removed is not an error.
+func (ds *DockerSandbox) removeContainer(ctx context.Context, id string) error {
+	err := ds.retry.Do(ctx, func(ctx context.Context) error {
+		err := ds.client.ContainerRemove(ctx, id, container.RemoveOptions{
+			Force:         true, // force remove
+			RemoveVolumes: true,
+		})
+		// Already removed, e.g. by the reaper.
+		if cerrdefs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove container %s: %w", id, err)
+	}
+	return nil
+}
+
+// createWorkVolume Create the work directory shared by the execution and install containers,
+// a tmpfs limited to the disk size like the work directory of the executions without dependencies.
+// Its content lives as long as a container mounts it.
+func (ds *DockerSandbox) createWorkVolume(ctx context.Context, name string) error {
+	options := map[string]string{"type": "tmpfs", "device": "tmpfs"}
+	if ds.config.Resource.DiskMb > 0 {
+		options["o"] = fmt.Sprintf("size=%dm", ds.config.Resource.DiskMb)
+	}
+	err := ds.retry.Do(ctx, func(ctx context.Context) error {
+		_, err := ds.client.VolumeCreate(ctx, volume.CreateOptions{
+			Name:       name,
+			Driver:     "local",
+			DriverOpts: options,
+			Labels:     sandboxLabels(ds.instanceID, ds.instanceName, time.Now(), ds.ttl),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create work volume: %w", err)
+	}
+	ds.mu.Lock()
+	ds.workVolume = name
+	ds.mu.Unlock()
+	return nil
+}
+
+// install Run the install phase in a separate container sharing the work volume of the execution container.
+// Only the install container mounts the package caches writable and has network access, so the code can not
+// poison the caches shared by the later executions. It is removed once the dependencies are installed.
+func (ds *DockerSandbox) install(ctx context.Context, name string, hostPath string, resourcesCfg *container.Resources, tmplData EntrypointTmpl) (*sandbox.PhaseResult, error) {
+	containerCfg := &container.Config{}
+	hostCfg := &container.HostConfig{}
+	WithOptions(
+		containerCfg,
+		WithImage(ds.config.Image),
+		WithCommand([]string{
+			"tail", "-f", "/dev/null",
+		}...),
+		WithLabels(sandboxLabels(ds.instanceID, ds.instanceName, time.Now(), ds.ttl)),
+	)
+	WithOptions(
+		hostCfg,
+		WithAutoRemove(false),
+		WithVolumeMount(ds.workVolume, tmplData.Path, false),
+		WithBindMount(hostPath, hostPath),
+		WithResources(resourcesCfg),
+	)
+	for _, volume := range ds.config.CacheVolumes {
+		WithOptions(hostCfg, WithVolumeMount(volume.Name, volume.Target, false))
+	}
+
+	id, err := ds.createContainer(ctx, containerCfg, hostCfg, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		cleanupCtx, cancel := ds.cleanupContext(ctx)
+		defer cancel()
+		if err := ds.removeContainer(cleanupCtx, id); err != nil {
+			sandbox.InternalLogger.Errorf("failed to remove install container: %v", err)
+		}
+	}()
+	if err := ds.startContainer(ctx, id); err != nil {
+		return nil, err
+	}
+	return ds.execPhase(ctx, id, ds.config.Install, tmplData)
+}
+
+// installsDependencies Whether the execution runs the install phase.
+func (ds *DockerSandbox) installsDependencies() bool {
+	return len(ds.config.Dependencies) > 0 && ds.config.Install != nil
+}
+
+// cpuTime Return the CPU time consumed by the container, 0 if the stats are unavailable.
+func (ds *DockerSandbox) cpuTime(ctx context.Context) time.Duration {
+	stats, err := ds.client.ContainerStatsOneShot(ctx, ds.containerID)
+	if err != nil {
+		sandbox.InternalLogger.Warnf("Failed to get container stats: %v", err)
+		return 0
+	}
+	defer stats.Body.Close()
+
+	var resp container.StatsResponse
+	if err := json.NewDecoder(stats.Body).Decode(&resp); err != nil {
+		sandbox.InternalLogger.Warnf("Failed to decode container stats: %v", err)
+		return 0
+	}
+	return time.Duration(resp.CPUStats.CPUUsage.TotalUsage)
+}
+
+// compile Run the compile phase, restoring the artifact from the cache when the same code was compiled before.
+func (ds *DockerSandbox) compile(ctx context.Context, code string, tmplData EntrypointTmpl) (*sandbox.PhaseResult, error) {
+	artifact := ds.config.Compile.Artifact
+	if ds.artifactCache == nil || artifact == "" {
+		return ds.execPhase(ctx, ds.containerID, ds.config.Compile, tmplData)
+	}
+
+	key := sandbox.ArtifactKey(ds.config, code)
+	if cached, ok := ds.artifactCache.Get(key); ok {
+		start := time.Now()
+		// The cached artifact is the tar archive returned by CopyFromContainer.
+		err := ds.client.CopyToContainer(ctx, ds.containerID, filepath.Dir(artifact), cached, container.CopyToContainerOptions{})
+		_ = cached.Close()
+		if err == nil {
+			sandbox.InternalLogger.Infof("Restore compiled artifact %s from cache", key)
+			return &sandbox.PhaseResult{Cached: true, Duration: time.Since(start)}, nil
+		}
+		sandbox.InternalLogger.Warnf("failed to restore compiled artifact, compiling again: %v", err)
+	}
+
+	result, err := ds.execPhase(ctx, ds.containerID, ds.config.Compile, tmplData)
+	if err != nil || !result.Succeeded() {
+		return result, err
+	}
+
+	archive, _, err := ds.client.CopyFromContainer(ctx, ds.containerID, artifact)
+	if err != nil {
+		sandbox.InternalLogger.Warnf("failed to copy compiled artifact %s: %v", artifact, err)
+		return result, nil
+	}
+	defer archive.Close()
+	if err := ds.artifactCache.Put(key, archive); err != nil {
+		sandbox.InternalLogger.Warnf("failed to cache compiled artifact: %v", err)
+	}
+	return result, nil
+}
+
+// execPhase Execute the command of a phase within the already running container.
+func (ds *DockerSandbox) execPhase(ctx context.Context, containerID string, phase *sandbox.PhaseConfig, tmplData EntrypointTmpl) (*sandbox.PhaseResult, error) {
 	// Dynamically construct the commands to be executed within the container based on the language.
-	execCmd, err := buildExecutionCommand(ctx, ds.config, path, hostPath)
+	execCmd, err := buildExecutionCommand(ctx, phase.Entrypoint, tmplData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get execution command: %w", err)
 	}
+	sandbox.InternalLogger.Infof("Build execution command successfully")
 
-	cmdCtx, cmdCancel := context.WithTimeout(ctx, ds.config.Resource.CpuTimeout)
+	start := time.Now()
+	cmdCtx, cmdCancel := context.WithTimeout(ctx, phase.Timeout)
 	defer cmdCancel()
 
-	// Execute commands within the already running container.
-	execResp, err := ds.client.ContainerExecCreate(cmdCtx, ds.containerID, container.ExecOptions{
+	timeoutResult := &sandbox.PhaseResult{
+		Stderr:   "command execution timeout",
+		ExitCode: 124,
+		TimedOut: true,
+		Duration: phase.Timeout,
+	}
+
+	execResp, err := ds.client.ContainerExecCreate(cmdCtx, containerID, container.ExecOptions{
 		Cmd:          execCmd,
 		AttachStdout: true,
 		AttachStderr: true,
@@ -164,13 +542,9 @@ func (ds *DockerSandbox) Execute(ctx context.Context, code string) (*sandbox.Exe
 	var stdoutBuf, stderrBuf strings.Builder
 	_, err = stdcopy.StdCopy(&stdoutBuf, &stderrBuf, attachResp.Reader)
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			return &sandbox.ExecutionResult{
-				Stdout:   "",
-				Stderr:   "command execution timeout",
-				ExitCode: 124,
-				Duration: ds.config.Resource.CpuTimeout,
-			}, nil
+		// The phase timed out unless the execution deadline expired first.
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return timeoutResult, nil
 		}
 		return nil, fmt.Errorf("failed to get container stdout: %w", err)
 	}
@@ -178,35 +552,17 @@ func (ds *DockerSandbox) Execute(ctx context.Context, code string) (*sandbox.Exe
 	// Check the exit status of the exec execution.
 	inspectResp, err := ds.client.ContainerExecInspect(cmdCtx, execResp.ID)
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			return &sandbox.ExecutionResult{
-				Stdout:   "",
-				Stderr:   "command execution timeout",
-				ExitCode: 124,
-				Duration: ds.config.Resource.CpuTimeout,
-			}, nil
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return timeoutResult, nil
 		}
 		return nil, fmt.Errorf("failed to get exec inspect: %w", err)
 	}
-	exitCode := inspectResp.ExitCode
-	duration := time.Since(start)
-
-	defer func() {
-		err := ds.Cleanup(ctx)
-		if err != nil {
-			sandbox.InternalLogger.Errorf("failed to clean up: %s", err.Error())
-		}
-		err = fileManager.Cleanup()
-		if err != nil {
-			sandbox.InternalLogger.Errorf("failed to clean up: %v", err)
-		}
-	}()
 
-	return &sandbox.ExecutionResult{
+	return &sandbox.PhaseResult{
 		Stdout:   stdoutBuf.String(),
 		Stderr:   stderrBuf.String(),
-		ExitCode: exitCode,
-		Duration: duration,
+		ExitCode: inspectResp.ExitCode,
+		Duration: time.Since(start),
 	}, nil
 }
 
@@ -216,61 +572,99 @@ func (ds *DockerSandbox) Cleanup(ctx context.Context) error {
 	defer ds.mu.Unlock()
 
 	// idempotence check
-	if ds.cleaned || ds.containerID == "" {
+	if ds.cleaned {
 		return nil
 	}
 
-	cleanupOperation := func(ctx context.Context) error {
-		return ds.client.ContainerRemove(ctx, ds.containerID, container.RemoveOptions{
-			Force:         true, // force remove
-			RemoveVolumes: true,
-		})
+	if ds.fileManager != nil {
+		if err := ds.fileManager.Cleanup(); err != nil {
+			sandbox.InternalLogger.Errorf("failed to clean up temp files: %v", err)
+		}
+		ds.fileManager = nil
 	}
-
-	retryDecorator := sandbox.WithRetry(3, 1)
-	retryableCleanup := retryDecorator(cleanupOperation)
-	err := retryableCleanup(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to remove container %s: %w", ds.containerID, err)
+	if ds.containerID != "" {
+		if err := ds.removeContainer(ctx, ds.containerID); err != nil {
+			return err
+		}
+		ds.containerID = ""
+	}
+	// The work volume is removed once no container mounts it.
+	if ds.workVolume != "" {
+		err := ds.retry.Do(ctx, func(ctx context.Context) error {
+			err := ds.client.VolumeRemove(ctx, ds.workVolume, true)
+			if cerrdefs.IsNotFound(err) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove work volume %s: %w", ds.workVolume, err)
+		}
+		ds.workVolume = ""
 	}
 
-	ds.containerID = ""
 	ds.cleaned = true
 	return nil
 }
 
-// ensureImage ensure image
+// ensureImage Make sure the image is present locally according to the pull policy.
 func (ds *DockerSandbox) ensureImage(ctx context.Context) error {
-	var buf bytes.Buffer
-	_, err := ds.client.ImageInspect(ctx, ds.config.Image, client.ImageInspectWithRawResponse(&buf))
-	if err == nil {
-		sandbox.InternalLogger.Ctx(ctx).Infof("Image %s already exists, skip pulling", ds.config.Image)
-		return nil
-	}
+	policy := ds.config.PullPolicy
+	if policy != sandbox.PullAlways {
+		_, err := ds.client.ImageInspect(ctx, ds.config.Image)
+		if err == nil {
+			sandbox.InternalLogger.Ctx(ctx).Infof("Image %s already exists, skip pulling", ds.config.Image)
+			return nil
+		}
 
-	// Is the mirror image not found
-	if !isImageNotFoundError(ctx, err) {
-		sandbox.InternalLogger.Ctx(ctx).Errorf("failed to inspect image: %s", err.Error())
-		return fmt.Errorf("failed to inspect image: %w", err)
+		// Is the mirror image not found
+		if !isImageNotFoundError(ctx, err) {
+			sandbox.InternalLogger.Ctx(ctx).Errorf("failed to inspect image: %s", err.Error())
+			return fmt.Errorf("failed to inspect image: %w", err)
+		}
+		if policy == sandbox.PullNever {
+			return fmt.Errorf("image %s is not present locally and the pull policy is %s", ds.config.Image, policy)
+		}
 	}
 
-	// image pull
-	pullResp, err := ds.client.ImagePull(ctx, ds.config.Image, image.PullOptions{})
-	if err != nil {
-		return fmt.Errorf("faild to pull image: %w", err)
+	// The derived image is built instead of pulled, the build pulls the base image.
+	if ds.config.Prebuilt != nil {
+		sandbox.InternalLogger.Ctx(ctx).Infof("Building image %s from %s", ds.config.Image, ds.baseImage)
+		sandbox.ReportProgress(ctx, 0, 0, fmt.Sprintf("Building image %s from %s", ds.config.Image, ds.baseImage))
+		return buildImage(ctx, ds.client, ds.registryAuth, policy == sandbox.PullAlways, ds.config.Language, ds.baseImage, ds.config.Image, ds.dockerfile, io.Discard)
 	}
 
-	defer func(pullResp io.ReadCloser) {
-		err := pullResp.Close()
+	// image pull
+	err := ds.retry.Do(ctx, func(ctx context.Context) error {
+		pullResp, err := pullImage(ctx, ds.client, ds.registryAuth, ds.config.Image)
 		if err != nil {
-			sandbox.InternalLogger.Errorf("failed to close pull image: %s", err.Error())
+			return err
 		}
-	}(pullResp)
 
-	_, err = io.Copy(io.Discard, pullResp)
+		defer func(pullResp io.ReadCloser) {
+			err := pullResp.Close()
+			if err != nil {
+				sandbox.InternalLogger.Errorf("failed to close pull image: %s", err.Error())
+			}
+		}(pullResp)
+
+		return reportPullProgress(ctx, ds.config.Image, pullResp)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to read pull response: %w", err)
+		return err
 	}
-
+	recordPull(ds.pullRecord, ds.config.Image)
 	return nil
 }
+
+// newClient Create a Docker client from the environment.
+func newClient() (*client.Client, error) {
+	cli, err := client.NewClientWithOpts(
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	return cli, nil
+}
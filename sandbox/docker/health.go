@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/client"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"sync"
+	"time"
+)
+
+// EngineStatus Result of the last health check of the Docker daemon.
+type EngineStatus struct {
+	Healthy       bool
+	Checked       time.Time
+	ServerVersion string // version of the daemon, empty if unreachable
+	Containers    int    // containers of the daemon
+	Err           error  // why the daemon is unhealthy
+}
+
+// Monitor Check the health of the Docker daemon in the background and drive the circuit breaker:
+// the breaker opens when a check fails and closes when the daemon recovers.
+type Monitor struct {
+	engine   *Client
+	breaker  *sandbox.Breaker
+	interval time.Duration
+	mu       sync.RWMutex
+	status   EngineStatus
+}
+
+// NewMonitor Create a health monitor checking the daemon every interval.
+func NewMonitor(engine *Client, breaker *sandbox.Breaker, interval time.Duration) *Monitor {
+	return &Monitor{
+		engine:   engine,
+		breaker:  breaker,
+		interval: interval,
+		status:   EngineStatus{Healthy: true, Checked: time.Now()},
+	}
+}
+
+// Run Check the daemon every interval until the context is done.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check(ctx)
+		}
+	}
+}
+
+// Check Ping the daemon, reconnecting if needed, then query its info.
+func (m *Monitor) Check(ctx context.Context) EngineStatus {
+	status := EngineStatus{Checked: time.Now()}
+	if err := m.engine.Check(ctx); err != nil {
+		status.Err = err
+	} else {
+		infoCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		info, err := m.engine.API().Info(infoCtx)
+		cancel()
+		if err != nil {
+			status.Err = fmt.Errorf("failed to get the Docker daemon info: %w", err)
+		} else {
+			status.Healthy = true
+			status.ServerVersion = info.ServerVersion
+			status.Containers = info.Containers
+		}
+	}
+
+	if status.Healthy {
+		m.breaker.Reset()
+	} else if ctx.Err() == nil {
+		sandbox.InternalLogger.Errorf("Docker daemon unhealthy: %v", status.Err)
+		m.breaker.Trip(status.Err)
+	}
+	m.mu.Lock()
+	m.status = status
+	m.mu.Unlock()
+	return status
+}
+
+// Status Return the result of the last health check.
+func (m *Monitor) Status() EngineStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// IsEngineUnavailable Whether the error comes from the Docker daemon being unreachable,
+// as opposed to a failure of the execution itself.
+func IsEngineUnavailable(err error) bool {
+	return err != nil && (client.IsErrConnectionFailed(err) || errors.Is(err, sandbox.ErrEngineUnavailable))
+}
@@ -0,0 +1,283 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"io"
+	"time"
+)
+
+// pullProgressInterval Min interval between two pull progress reports.
+const pullProgressInterval = 500 * time.Millisecond
+
+// ImageManager Manage the sandbox images of the Docker engine.
+type ImageManager struct {
+	client       *client.Client
+	registryAuth *RegistryAuthResolver
+	pullRecord   *PullRecord // base images pulled, nil if not recorded
+}
+
+// NewImageManager Create an image manager connected to the Docker engine, recording the pulled images in record.
+func NewImageManager(resolver *RegistryAuthResolver, record *PullRecord) (*ImageManager, error) {
+	cli, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	return &ImageManager{client: cli, registryAuth: resolver, pullRecord: record}, nil
+}
+
+// Close Close the Docker client.
+func (m *ImageManager) Close() error {
+	return m.client.Close()
+}
+
+// ResolveImages Render the base image of the language version and the image the sandbox runs,
+// which is the derived image when the language declares prebuilt packages.
+func ResolveImages(ctx context.Context, config *sandbox.Config) (string, string, error) {
+	if config.Version == "" {
+		config.Version = config.BaseImage
+	}
+	baseImage, err := getRuntimeImage(ctx, config)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get image: %w", err)
+	}
+	if config.Prebuilt == nil {
+		return baseImage, baseImage, nil
+	}
+	derived, _, err := derivedImage(config.Language, baseImage, config.Prebuilt)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render prebuilt image: %w", err)
+	}
+	return baseImage, derived, nil
+}
+
+// Exists Whether the image is available locally.
+func (m *ImageManager) Exists(ctx context.Context, ref string) (bool, error) {
+	return imageExists(ctx, m.client, ref)
+}
+
+// ImageExists Whether the image is available in the engine.
+func ImageExists(ctx context.Context, engine *Client, ref string) (bool, error) {
+	return imageExists(ctx, engine.API(), ref)
+}
+
+// imageExists Whether the image is available in the engine of the client.
+func imageExists(ctx context.Context, cli *client.Client, ref string) (bool, error) {
+	_, err := cli.ImageInspect(ctx, ref)
+	if err == nil {
+		return true, nil
+	}
+	if isImageNotFoundError(ctx, err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Pull Pull the image, writing the pull progress to out.
+func (m *ImageManager) Pull(ctx context.Context, ref string, out io.Writer) error {
+	pullResp, err := pullImage(ctx, m.client, m.registryAuth, ref)
+	if err != nil {
+		return err
+	}
+	defer func(pullResp io.ReadCloser) {
+		err := pullResp.Close()
+		if err != nil {
+			sandbox.InternalLogger.Errorf("failed to close pull image: %s", err.Error())
+		}
+	}(pullResp)
+
+	if err := jsonmessage.DisplayJSONMessagesStream(pullResp, out, 0, false, nil); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	recordPull(m.pullRecord, ref)
+	return nil
+}
+
+// recordPull Record the pulled image, a failure only loses the ability to prune it.
+func recordPull(record *PullRecord, ref string) {
+	if record == nil {
+		return
+	}
+	if err := record.Add(ref); err != nil {
+		sandbox.InternalLogger.Warnf("Failed to record the pull of %s: %v", ref, err)
+	}
+}
+
+// Prune Remove the derived sandbox images not referenced by keep and, with base, the recorded base images
+// keep does not reference. Only the images the server owns are removed: the derived images carry labelLanguage
+// and the base images are in the pull record, other images of the same repositories are left untouched,
+// as are the images used by a container. Return the removed references.
+func (m *ImageManager) Prune(ctx context.Context, keep []string, base bool) ([]string, error) {
+	kept := map[string]bool{}
+	for _, ref := range keep {
+		keys, err := referenceKeys(ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			kept[key] = true
+		}
+	}
+
+	removed, err := m.pruneDerived(ctx, kept)
+	if err != nil || !base {
+		return removed, err
+	}
+	removedBase, err := m.pruneBase(ctx, kept)
+	return append(removed, removedBase...), err
+}
+
+// pruneDerived Remove the derived images without a kept tag or digest.
+func (m *ImageManager) pruneDerived(ctx context.Context, kept map[string]bool) ([]string, error) {
+	images, err := m.client.ImageList(ctx, image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", labelLanguage)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var removed []string
+	for _, summary := range images {
+		if isKept(append(append([]string{}, summary.RepoTags...), summary.RepoDigests...), kept) {
+			continue
+		}
+		if _, err := m.client.ImageRemove(ctx, summary.ID, image.RemoveOptions{PruneChildren: true}); err != nil {
+			// The image is still used by a container.
+			if cerrdefs.IsConflict(err) {
+				continue
+			}
+			return removed, fmt.Errorf("failed to remove image %s: %w", summary.ID, err)
+		}
+		if len(summary.RepoTags) > 0 {
+			removed = append(removed, summary.RepoTags...)
+		} else {
+			removed = append(removed, summary.ID)
+		}
+	}
+	return removed, nil
+}
+
+// pruneBase Remove the recorded base images keep does not reference, by reference so that only the recorded
+// name is dropped and the image is deleted with its last name. The children of the images are kept.
+func (m *ImageManager) pruneBase(ctx context.Context, kept map[string]bool) ([]string, error) {
+	if m.pullRecord == nil {
+		return nil, nil
+	}
+	refs, err := m.pullRecord.Refs()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed, forgotten []string
+	for _, ref := range refs {
+		if isKept([]string{ref}, kept) {
+			continue
+		}
+		_, err := m.client.ImageRemove(ctx, ref, image.RemoveOptions{})
+		switch {
+		case err == nil:
+			removed = append(removed, ref)
+			forgotten = append(forgotten, ref)
+		case cerrdefs.IsNotFound(err):
+			// Removed by someone else.
+			forgotten = append(forgotten, ref)
+		case cerrdefs.IsConflict(err):
+			// The image is still used by a container.
+		default:
+			_ = m.pullRecord.Remove(forgotten)
+			return removed, fmt.Errorf("failed to remove image %s: %w", ref, err)
+		}
+	}
+	return removed, m.pullRecord.Remove(forgotten)
+}
+
+// referenceKeys Return the normalized tag and digest of the image reference, e.g. docker.io/library/python:3.12.
+func referenceKeys(ref string) ([]string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference %q: %w", ref, err)
+	}
+	var keys []string
+	if tagged, ok := named.(reference.Tagged); ok {
+		keys = append(keys, named.Name()+":"+tagged.Tag())
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		keys = append(keys, named.Name()+"@"+digested.Digest().String())
+	}
+	return keys, nil
+}
+
+// isKept Whether one of the references is kept.
+func isKept(refs []string, kept map[string]bool) bool {
+	for _, ref := range refs {
+		keys, err := referenceKeys(ref)
+		if err != nil {
+			continue
+		}
+		for _, key := range keys {
+			if kept[key] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pullImage Pull the image with the credentials of its registry and return the pull stream.
+func pullImage(ctx context.Context, cli *client.Client, resolver *RegistryAuthResolver, ref string) (io.ReadCloser, error) {
+	auth, err := resolver.EncodedAuth(ref)
+	if err != nil {
+		return nil, err
+	}
+	pullResp, err := cli.ImagePull(ctx, ref, image.PullOptions{RegistryAuth: auth})
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	return pullResp, nil
+}
+
+// reportPullProgress Consume the pull stream, reporting the downloaded bytes of all layers to the context.
+func reportPullProgress(ctx context.Context, ref string, stream io.Reader) error {
+	decoder := json.NewDecoder(stream)
+	layers := map[string]*jsonmessage.JSONProgress{}
+	var lastReport time.Time
+	var current, total int64
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to read pull response: %w", err)
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("failed to pull image %s: %w", ref, msg.Error)
+		}
+		if msg.ID != "" && msg.Progress != nil && msg.Progress.Total > 0 {
+			layers[msg.ID] = msg.Progress
+		}
+		if time.Since(lastReport) < pullProgressInterval {
+			continue
+		}
+		lastReport = time.Now()
+
+		current, total = 0, 0
+		for _, layer := range layers {
+			current += layer.Current
+			total += layer.Total
+		}
+		sandbox.ReportProgress(ctx, float64(current), float64(total), fmt.Sprintf("Pulling %s: %s", ref, msg.Status))
+	}
+
+	sandbox.ReportProgress(ctx, float64(total), float64(total), fmt.Sprintf("Pulled %s", ref))
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
-	"time"
 )
 
 type ImageTmpl struct {
@@ -15,6 +14,7 @@ type ImageTmpl struct {
 type EntrypointTmpl struct {
 	ExecFile string `json:"exec_file"`
 	Path     string `json:"path"`
+	Packages string `json:"packages"`
 }
 
 type ConfigOption func(*container.Config)
@@ -37,6 +37,12 @@ func WithImage(image string) ConfigOption {
 	}
 }
 
+func WithLabels(labels map[string]string) ConfigOption {
+	return func(cfg *container.Config) {
+		cfg.Labels = labels
+	}
+}
+
 func WithCommand(cmd ...string) ConfigOption {
 	return func(cfg *container.Config) {
 		cfg.Cmd = cmd
@@ -53,12 +59,31 @@ func WithBindMount(source string, target string) HostConfigOption {
 	}
 }
 
+// WithVolumeMount Mount the named volume, read-only if readOnly.
+func WithVolumeMount(name string, target string, readOnly bool) HostConfigOption {
+	return func(cfg *container.HostConfig) {
+		cfg.Mounts = append(cfg.Mounts, mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   name,
+			Target:   target,
+			ReadOnly: readOnly,
+		})
+	}
+}
+
 func WithAutoRemove(autoRemove bool) HostConfigOption {
 	return func(cfg *container.HostConfig) {
 		cfg.AutoRemove = autoRemove
 	}
 }
 
+// WithNetworkMode Set the network of the container, "none" isolates it.
+func WithNetworkMode(mode string) HostConfigOption {
+	return func(cfg *container.HostConfig) {
+		cfg.NetworkMode = container.NetworkMode(mode)
+	}
+}
+
 func WithMemory(memoryMb int64) ResourceConfigOption {
 	return func(cfg *container.Resources) {
 		limitMemory := memoryMb * 1024 * 1024
@@ -75,9 +100,16 @@ func WithDiskMb(baseDir string, diskMb int64) HostConfigOption {
 	}
 }
 
-func WithCpuTimeout(cpuLimit time.Duration) ResourceConfigOption {
+// WithCpus Limit the CPUs the container may use, unlimited if 0.
+func WithCpus(cpus float64) ResourceConfigOption {
 	return func(cfg *container.Resources) {
-		cfg.CPUPeriod = 100000
-		cfg.CPUQuota = int64(float64(cfg.CPUPeriod) * 100000)
+		cfg.NanoCPUs = int64(cpus * 1e9)
+	}
+}
+
+// WithResources Apply the resource limits to the container.
+func WithResources(resources *container.Resources) HostConfigOption {
+	return func(cfg *container.HostConfig) {
+		cfg.Resources = *resources
 	}
 }
@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// PullRecord File recording the base images pulled by the server and the images command, one reference per line.
+// `images prune -base` only removes the base images it recorded, never the other images of the same repositories.
+type PullRecord struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewPullRecord Create the record stored at path.
+func NewPullRecord(path string) *PullRecord {
+	return &PullRecord{path: path}
+}
+
+// Add Record a pulled image, appending to the file so the server and the images command can share it.
+func (r *PullRecord) Add(ref string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	refs, err := r.read()
+	if err != nil {
+		return err
+	}
+	for _, recorded := range refs {
+		if recorded == ref {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create pull record dir: %w", err)
+	}
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open pull record: %w", err)
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintln(file, ref); err != nil {
+		return fmt.Errorf("failed to write pull record: %w", err)
+	}
+	return nil
+}
+
+// Refs Return the recorded images.
+func (r *PullRecord) Refs() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read()
+}
+
+// Remove Forget the images.
+func (r *PullRecord) Remove(refs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removed := map[string]bool{}
+	for _, ref := range refs {
+		removed[ref] = true
+	}
+	recorded, err := r.read()
+	if err != nil {
+		return err
+	}
+	var kept []string
+	for _, ref := range recorded {
+		if !removed[ref] {
+			kept = append(kept, ref+"\n")
+		}
+	}
+	if err := os.WriteFile(r.path, []byte(strings.Join(kept, "")), 0644); err != nil {
+		return fmt.Errorf("failed to write pull record: %w", err)
+	}
+	return nil
+}
+
+// read Return the deduplicated references of the file, none if it does not exist, the caller must hold mu.
+func (r *PullRecord) read() ([]string, error) {
+	file, err := os.Open(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pull record: %w", err)
+	}
+	defer file.Close()
+
+	seen := map[string]bool{}
+	var refs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		ref := strings.TrimSpace(scanner.Text())
+		if ref != "" && !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pull record: %w", err)
+	}
+	return refs, nil
+}
@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// labelInstance Label holding the ID of the server instance owning the container.
+	labelInstance = "mcp-sandbox.instance"
+	// labelName Label holding the configured name of the server instance owning the container.
+	labelName = "mcp-sandbox.name"
+	// labelHost Label holding the hostname of the server owning the container.
+	labelHost = "mcp-sandbox.host"
+	// labelCreated Label holding the creation time of the container in unix seconds.
+	labelCreated = "mcp-sandbox.created"
+	// labelTTL Label holding the ttl of the container in seconds.
+	labelTTL = "mcp-sandbox.ttl"
+)
+
+// sandboxLabels Return the labels of a sandbox container.
+func sandboxLabels(instanceID string, name string, created time.Time, ttl time.Duration) map[string]string {
+	hostname, _ := os.Hostname()
+	return map[string]string{
+		labelInstance: instanceID,
+		labelName:     name,
+		labelHost:     hostname,
+		labelCreated:  strconv.FormatInt(created.Unix(), 10),
+		labelTTL:      strconv.FormatInt(int64(ttl.Seconds()), 10),
+	}
+}
+
+// Reaper Remove the sandbox containers and work volumes leaked by server crashes or failed cleanups.
+type Reaper struct {
+	engine     *Client
+	instanceID string
+	name       string
+	interval   time.Duration
+}
+
+// NewReaper Create a reaper of the server instance, reaping expired containers every interval.
+// The name is the configured name of the instance, the same across its restarts, empty if not configured.
+func NewReaper(engine *Client, instanceID string, name string, interval time.Duration) *Reaper {
+	return &Reaper{
+		engine:     engine,
+		instanceID: instanceID,
+		name:       name,
+		interval:   interval,
+	}
+}
+
+// Run Reap expired containers every interval until the context is done.
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := r.Reap(ctx)
+			if err != nil {
+				sandbox.InternalLogger.Errorf("failed to reap containers: %v", err)
+			}
+			if removed > 0 {
+				sandbox.InternalLogger.Warnf("Reaped %d expired sandbox containers", removed)
+			}
+		}
+	}
+}
+
+// Reconcile Remove every expired container, and the containers left over by previous runs of the instance
+// when it has a configured name. Other instances sharing the Docker engine, even on the same host,
+// can not be told apart from dead ones, so their containers are kept until they expire.
+func (r *Reaper) Reconcile(ctx context.Context) (int, error) {
+	return r.remove(ctx, func(labels map[string]string, now time.Time) bool {
+		leftover := r.name != "" && labels[labelName] == r.name && labels[labelInstance] != r.instanceID
+		return leftover || expired(labels, now)
+	})
+}
+
+// RemoveInstance Remove every container of this server instance.
+func (r *Reaper) RemoveInstance(ctx context.Context) (int, error) {
+	return r.remove(ctx, func(labels map[string]string, now time.Time) bool {
+		return labels[labelInstance] == r.instanceID
+	})
+}
+
+// Reap Remove the sandbox containers that outlived their ttl.
+func (r *Reaper) Reap(ctx context.Context) (int, error) {
+	return r.remove(ctx, func(labels map[string]string, now time.Time) bool {
+		return expired(labels, now)
+	})
+}
+
+// remove Remove the sandbox containers matching shouldRemove, return the number of removed containers.
+func (r *Reaper) remove(ctx context.Context, shouldRemove func(labels map[string]string, now time.Time) bool) (int, error) {
+	containers, err := r.engine.API().ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", labelInstance)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list sandbox containers: %w", err)
+	}
+
+	now := time.Now()
+	removed := 0
+	for _, summary := range containers {
+		if !shouldRemove(summary.Labels, now) {
+			continue
+		}
+		err := r.engine.API().ContainerRemove(ctx, summary.ID, container.RemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		})
+		if err != nil && !cerrdefs.IsNotFound(err) {
+			sandbox.InternalLogger.Errorf("failed to remove container %s: %v", summary.ID, err)
+			continue
+		}
+		removed++
+	}
+	r.removeVolumes(ctx, now, shouldRemove)
+	return removed, nil
+}
+
+// removeVolumes Remove the work volumes matching shouldRemove, the volumes still mounted by a container are kept.
+func (r *Reaper) removeVolumes(ctx context.Context, now time.Time, shouldRemove func(labels map[string]string, now time.Time) bool) {
+	volumes, err := r.engine.API().VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", labelInstance)),
+	})
+	if err != nil {
+		sandbox.InternalLogger.Errorf("failed to list sandbox volumes: %v", err)
+		return
+	}
+	for _, v := range volumes.Volumes {
+		if !shouldRemove(v.Labels, now) {
+			continue
+		}
+		err := r.engine.API().VolumeRemove(ctx, v.Name, false)
+		if err != nil && !cerrdefs.IsNotFound(err) && !cerrdefs.IsConflict(err) {
+			sandbox.InternalLogger.Errorf("failed to remove volume %s: %v", v.Name, err)
+		}
+	}
+}
+
+// expired Whether the container outlived its ttl, containers without a ttl never expire.
+func expired(labels map[string]string, now time.Time) bool {
+	created, err := strconv.ParseInt(labels[labelCreated], 10, 64)
+	if err != nil {
+		return false
+	}
+	ttl, err := strconv.ParseInt(labels[labelTTL], 10, 64)
+	if err != nil || ttl <= 0 {
+		return false
+	}
+	return now.After(time.Unix(created, 0).Add(time.Duration(ttl) * time.Second))
+}
@@ -0,0 +1,130 @@
+package docker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubAuthKey Key of Docker Hub in the auths of the docker config file.
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// dockerConfigFile The subset of the docker config file holding registry credentials.
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+}
+
+// RegistryAuthResolver Resolve the registry credentials of image references,
+// from the configured credentials first and then from the docker config file.
+type RegistryAuthResolver struct {
+	credentials  map[string]registry.AuthConfig // configured credentials keyed by registry host
+	dockerConfig string                         // path of the docker config file
+}
+
+// NewRegistryAuthResolver Create a registry auth resolver.
+// dockerConfig is the path of the docker config file, $DOCKER_CONFIG/config.json or ~/.docker/config.json if empty.
+func NewRegistryAuthResolver(credentials []sandbox.RegistryCredential, dockerConfig string) *RegistryAuthResolver {
+	if dockerConfig == "" {
+		if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+			dockerConfig = filepath.Join(dir, "config.json")
+		} else if home, err := os.UserHomeDir(); err == nil {
+			dockerConfig = filepath.Join(home, ".docker", "config.json")
+		}
+	}
+
+	resolver := &RegistryAuthResolver{
+		credentials:  map[string]registry.AuthConfig{},
+		dockerConfig: dockerConfig,
+	}
+	for _, credential := range credentials {
+		resolver.credentials[credential.Host] = registry.AuthConfig{
+			Username:      credential.Username,
+			Password:      credential.Password,
+			IdentityToken: credential.IdentityToken,
+			ServerAddress: credential.Host,
+		}
+	}
+	return resolver
+}
+
+// EncodedAuth Return the base64 encoded credentials for the registry of the image, empty if there are none.
+func (r *RegistryAuthResolver) EncodedAuth(ref string) (string, error) {
+	_, authConfig, ok, err := r.AuthConfig(ref)
+	if err != nil || !ok {
+		return "", err
+	}
+	return registry.EncodeAuthConfig(authConfig)
+}
+
+// AuthConfig Return the registry host of the image and its credentials, if there are any.
+func (r *RegistryAuthResolver) AuthConfig(ref string) (string, registry.AuthConfig, bool, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", registry.AuthConfig{}, false, fmt.Errorf("invalid image reference %s: %w", ref, err)
+	}
+	host := reference.Domain(named)
+	if r == nil {
+		return host, registry.AuthConfig{}, false, nil
+	}
+
+	if authConfig, ok := r.credentials[host]; ok {
+		return host, authConfig, true, nil
+	}
+	authConfig, ok, err := r.fromDockerConfig(host)
+	return host, authConfig, ok, err
+}
+
+// fromDockerConfig Read the credentials of the registry host from the docker config file.
+// Credential helpers (credsStore, credHelpers) are not supported.
+func (r *RegistryAuthResolver) fromDockerConfig(host string) (registry.AuthConfig, bool, error) {
+	if r.dockerConfig == "" {
+		return registry.AuthConfig{}, false, nil
+	}
+	content, err := os.ReadFile(r.dockerConfig)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return registry.AuthConfig{}, false, nil
+		}
+		return registry.AuthConfig{}, false, fmt.Errorf("failed to read docker config: %w", err)
+	}
+
+	var config dockerConfigFile
+	if err := json.Unmarshal(content, &config); err != nil {
+		return registry.AuthConfig{}, false, fmt.Errorf("failed to parse docker config: %w", err)
+	}
+
+	keys := []string{host, "https://" + host}
+	if host == "docker.io" {
+		keys = append([]string{dockerHubAuthKey}, keys...)
+	}
+	for _, key := range keys {
+		entry, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		authConfig := registry.AuthConfig{
+			IdentityToken: entry.IdentityToken,
+			ServerAddress: key,
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return registry.AuthConfig{}, false, fmt.Errorf("invalid auth of %s in docker config: %w", key, err)
+			}
+			username, password, _ := strings.Cut(string(decoded), ":")
+			authConfig.Username = username
+			authConfig.Password = password
+		}
+		return authConfig, true, nil
+	}
+	return registry.AuthConfig{}, false, nil
+}
@@ -4,14 +4,24 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/client"
 	"github.com/lemonlyue/code-sandbox-mcp/sandbox"
 	"strings"
 	"text/template"
 )
 
 // getRuntimeImage Return the Docker image based on language and version.
+// The version and the rendered image are validated, and the image is pinned to the configured digest.
 func getRuntimeImage(ctx context.Context, config *sandbox.Config) (string, error) {
-	tmpl := template.Must(template.New("docker").Parse(config.Image))
+	if err := sandbox.ValidateVersion(config.Version, config.VersionPattern); err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New("docker").Parse(config.Image)
+	if err != nil {
+		return "", err
+	}
 
 	data := ImageTmpl{
 		Version:  config.Version,
@@ -22,23 +32,25 @@ func getRuntimeImage(ctx context.Context, config *sandbox.Config) (string, error
 	if err := tmpl.Execute(&buf, data); err != nil {
 		return "", err
 	}
-	return buf.String(), nil
+	if err := sandbox.ValidateImage(buf.String(), config.AllowedRepositories); err != nil {
+		return "", err
+	}
+	return sandbox.PinImage(buf.String(), config.ImageDigest)
 }
 
 // buildExecutionCommand build execution command
-func buildExecutionCommand(ctx context.Context, config *sandbox.Config, path string, filePath string) ([]string, error) {
-	if len(config.Entrypoint) != 3 {
+func buildExecutionCommand(ctx context.Context, command []string, data EntrypointTmpl) ([]string, error) {
+	if len(command) != 3 {
 		return []string{}, errors.New("failed to build execution command")
 	}
 
-	entrypoint := make([]string, len(config.Entrypoint))
-	copy(entrypoint, config.Entrypoint)
+	entrypoint := make([]string, len(command))
+	copy(entrypoint, command)
 
 	execCommand := entrypoint[2]
-	tmpl := template.Must(template.New("command").Parse(execCommand))
-	data := EntrypointTmpl{
-		ExecFile: filePath,
-		Path:     path,
+	tmpl, err := template.New("command").Parse(execCommand)
+	if err != nil {
+		return []string{}, err
 	}
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
@@ -49,7 +61,29 @@ func buildExecutionCommand(ctx context.Context, config *sandbox.Config, path str
 	return entrypoint, nil
 }
 
+// quotePackages Single quote the packages so they are passed to the shell as literal arguments.
+func quotePackages(packages []string) string {
+	quoted := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		quoted = append(quoted, "'"+strings.ReplaceAll(pkg, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
+
 // isImageNotFoundError
 func isImageNotFoundError(ctx context.Context, err error) bool {
-	return strings.Contains(err.Error(), "No such image")
+	return cerrdefs.IsNotFound(err)
+}
+
+// IsRetryable Classify the engine errors: the daemon being unreachable, busy or in a conflicting state is transient,
+// missing objects, invalid requests, auth failures and cancellations are permanent.
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case client.IsErrConnectionFailed(err):
+		return true
+	default:
+		return cerrdefs.IsConflict(err) || cerrdefs.IsUnavailable(err) || cerrdefs.IsInternal(err) || cerrdefs.IsResourceExhausted(err)
+	}
 }
@@ -0,0 +1,138 @@
+package sandbox
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// envPrefix Prefix of the env vars overriding config keys, e.g. SANDBOX_RUNTIMES_RESOURCES_MEMORY_MB.
+	envPrefix = "SANDBOX_"
+	// envConfig Env var holding the config file.
+	envConfig = "SANDBOX_CONFIG"
+	// envConfigDir Env var holding the directory of the config fragments.
+	envConfigDir = "SANDBOX_CONFIG_DIR"
+)
+
+// isFragment Whether the file is a config fragment.
+func isFragment(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// mergeFragments Merge the fragments of dir over the config in lexical order, a missing dir is ignored.
+// Maps are merged key by key, lists and scalars of a fragment replace the previous value.
+func mergeFragments(v *viper.Viper, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config fragments: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isFragment(entry.Name()) || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open config fragment: %w", err)
+		}
+		err = v.MergeConfig(file)
+		_ = file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to merge config fragment %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// applyEnvOverrides Override the config keys with the SANDBOX_ env vars.
+// The env var of a key is its path upper-cased with the dots replaced by underscores,
+// list values are comma separated. Lists of sections can not be overridden.
+func applyEnvOverrides(v *viper.Viper, environ []string) {
+	keys := map[string]string{}
+	for _, key := range configKeys(v) {
+		keys[envName(key)] = key
+	}
+
+	for _, entry := range environ {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(name, envPrefix) || name == envConfig || name == envConfigDir {
+			continue
+		}
+		if key, ok := keys[name]; ok {
+			v.Set(key, value)
+		}
+	}
+}
+
+// envName Return the env var overriding the key.
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// configKeys Return the keys of the config schema, the entries of the maps are the configured ones,
+// plus one per language for the maps keyed by language.
+func configKeys(v *viper.Viper) []string {
+	languages := map[string]bool{}
+	for language := range v.GetStringMap("languages") {
+		languages[language] = true
+	}
+
+	seen := map[string]bool{}
+	var keys []string
+	var walk func(prefix string, t reflect.Type)
+	walk = func(prefix string, t reflect.Type) {
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch {
+		case t.Kind() == reflect.Struct && t != reflect.TypeOf(time.Duration(0)):
+			for i := 0; i < t.NumField(); i++ {
+				tag := strings.Split(t.Field(i).Tag.Get("mapstructure"), ",")[0]
+				if tag == "" || tag == "-" {
+					continue
+				}
+				walk(joinKey(prefix, tag), t.Field(i).Type)
+			}
+		case t.Kind() == reflect.Map:
+			names := map[string]bool{}
+			for name := range v.GetStringMap(prefix) {
+				names[name] = true
+			}
+			for language := range languages {
+				names[language] = true
+			}
+			for name := range names {
+				walk(joinKey(prefix, name), t.Elem())
+			}
+		case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct:
+			// lists of sections are only configured in files
+		default:
+			if !seen[prefix] {
+				seen[prefix] = true
+				keys = append(keys, prefix)
+			}
+		}
+	}
+	walk("", reflect.TypeOf(SandboxConfig{}))
+	sort.Strings(keys)
+	return keys
+}
+
+// joinKey Join a key path and a key.
+func joinKey(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,66 @@
+package sandbox
+
+import (
+	"fmt"
+	"github.com/distribution/reference"
+	"path"
+	"regexp"
+)
+
+// DefaultVersionPattern Versions are restricted to a single image tag component,
+// so a version can not inject another repository, tag or digest into the base image template.
+var DefaultVersionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// digestPattern Pinned image digests.
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// ValidateVersion Check the requested version against the version pattern of the language,
+// DefaultVersionPattern if pattern is empty.
+func ValidateVersion(version string, pattern string) error {
+	versionPattern := DefaultVersionPattern
+	if pattern != "" {
+		var err error
+		if versionPattern, err = regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+			return fmt.Errorf("invalid version pattern %q: %w", pattern, err)
+		}
+	}
+	if !versionPattern.MatchString(version) {
+		return fmt.Errorf("invalid version %q", version)
+	}
+	return nil
+}
+
+// ValidateImage Check the repository of the image reference against the allowlist.
+// Allowlist entries are normalized repository names and may contain glob patterns, such as `docker.io/library/*`.
+// An empty allowlist allows every repository.
+func ValidateImage(ref string, allowlist []string) error {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return fmt.Errorf("invalid image reference %q: %w", ref, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return fmt.Errorf("image reference %q must not contain a digest, pin digests in the config", ref)
+	}
+	if len(allowlist) == 0 {
+		return nil
+	}
+
+	repository := named.Name()
+	for _, pattern := range allowlist {
+		if ok, _ := path.Match(pattern, repository); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("image repository %s is not in the allowlist", repository)
+}
+
+// PinImage Pin the image reference to the digest, the reference is returned unchanged if digest is empty.
+func PinImage(ref string, digest string) (string, error) {
+	if digest == "" {
+		return ref, nil
+	}
+	if !digestPattern.MatchString(digest) {
+		return "", fmt.Errorf("invalid image digest %q", digest)
+	}
+	return ref + "@" + digest, nil
+}
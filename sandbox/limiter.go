@@ -0,0 +1,214 @@
+package sandbox
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull The execution queue is full.
+	ErrQueueFull = errors.New("execution queue is full")
+	// ErrQueueTimeout The execution waited in the queue longer than the queue timeout.
+	ErrQueueTimeout = errors.New("timed out waiting in the execution queue")
+)
+
+// LimiterConfig Concurrency limits of the executions, 0 means unlimited.
+type LimiterConfig struct {
+	MaxConcurrent int            // max running executions of all languages
+	Languages     map[string]int // max running executions per language
+	QueueSize     int            // max executions waiting for a slot, 0 rejects the executions over the limits
+	QueueTimeout  time.Duration  // max time an execution waits for a slot
+}
+
+// Limiter Limit the running executions globally and per language.
+// Executions over the limits wait in a bounded FIFO queue, a waiting execution is admitted
+// before the ones queued after it unless its language limit is reached.
+type Limiter struct {
+	config  LimiterConfig
+	mu      sync.Mutex
+	running int
+	perLang map[string]int
+	queue   *list.List // of *waiter
+}
+
+// waiter Execution waiting in the queue.
+type waiter struct {
+	language string
+	ready    chan struct{} // closed once the execution holds a slot
+	moved    chan struct{} // signaled when the position in the queue changes
+}
+
+// NewLimiter Create a concurrency limiter.
+func NewLimiter(config LimiterConfig) *Limiter {
+	return &Limiter{
+		config:  config,
+		perLang: make(map[string]int),
+		queue:   list.New(),
+	}
+}
+
+// Acquire Wait for an execution slot of the language, reporting the queue position to the context.
+// The returned func releases the slot and must be called once the execution is done.
+func (l *Limiter) Acquire(ctx context.Context, language string) (func(), error) {
+	l.mu.Lock()
+	// The queued executions are all blocked by a limit, so an admissible one does not overtake them.
+	if l.admissible(language) {
+		l.take(language)
+		l.mu.Unlock()
+		return l.releaseFunc(language), nil
+	}
+	if l.queue.Len() >= l.config.QueueSize {
+		l.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	w := &waiter{
+		language: language,
+		ready:    make(chan struct{}),
+		moved:    make(chan struct{}, 1),
+	}
+	elem := l.queue.PushBack(w)
+	position := l.queue.Len()
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.config.QueueTimeout > 0 {
+		timer := time.NewTimer(l.config.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// The progress is the number of positions advanced, out of the initial position.
+	initial := position
+	ReportProgress(ctx, 0, float64(initial), fmt.Sprintf("Queued at position %d", position))
+	for {
+		select {
+		case <-w.ready:
+			ReportProgress(ctx, float64(initial), float64(initial), "Execution slot acquired")
+			return l.releaseFunc(language), nil
+		case <-w.moved:
+			if p := l.position(elem); p > 0 && p < position {
+				position = p
+				ReportProgress(ctx, float64(initial-position), float64(initial), fmt.Sprintf("Queued at position %d", position))
+			}
+		case <-timeout:
+			return nil, l.abandon(elem, w, ErrQueueTimeout)
+		case <-ctx.Done():
+			return nil, l.abandon(elem, w, ctx.Err())
+		}
+	}
+}
+
+// SetConfig Apply new limits, the running executions keep their slots and the queued ones are admitted if the limits grew.
+func (l *Limiter) SetConfig(config LimiterConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.config = config
+	l.dispatch()
+}
+
+// Stats Return the number of running and queued executions.
+func (l *Limiter) Stats() (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.running, l.queue.Len()
+}
+
+// admissible Whether an execution of the language can run now, the caller must hold mu.
+func (l *Limiter) admissible(language string) bool {
+	if l.config.MaxConcurrent > 0 && l.running >= l.config.MaxConcurrent {
+		return false
+	}
+	if max := l.config.Languages[language]; max > 0 && l.perLang[language] >= max {
+		return false
+	}
+	return true
+}
+
+// take Occupy a slot of the language, the caller must hold mu.
+func (l *Limiter) take(language string) {
+	l.running++
+	l.perLang[language]++
+}
+
+// releaseFunc Return the func releasing a slot of the language, it is safe to call more than once.
+func (l *Limiter) releaseFunc(language string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.running--
+			l.perLang[language]--
+			l.dispatch()
+		})
+	}
+}
+
+// dispatch Hand the free slots to the queued executions in FIFO order, the caller must hold mu.
+func (l *Limiter) dispatch() {
+	admitted := false
+	for elem := l.queue.Front(); elem != nil; {
+		next := elem.Next()
+		w := elem.Value.(*waiter)
+		if l.admissible(w.language) {
+			l.take(w.language)
+			l.queue.Remove(elem)
+			close(w.ready)
+			admitted = true
+		}
+		elem = next
+	}
+	if admitted {
+		l.notifyMoved()
+	}
+}
+
+// abandon Remove the waiter from the queue, unless it was admitted in the meantime in which case its slot is released.
+func (l *Limiter) abandon(elem *list.Element, w *waiter, err error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		l.running--
+		l.perLang[w.language]--
+		l.dispatch()
+	default:
+		l.queue.Remove(elem)
+		l.notifyMoved()
+	}
+	return err
+}
+
+// position Return the 1-based position of the waiter in the queue, 0 if it is no longer queued.
+func (l *Limiter) position(elem *list.Element) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	position := 1
+	for e := l.queue.Front(); e != nil; e = e.Next() {
+		if e == elem {
+			return position
+		}
+		position++
+	}
+	return 0
+}
+
+// notifyMoved Signal the queued executions that their position may have changed, the caller must hold mu.
+func (l *Limiter) notifyMoved() {
+	for e := l.queue.Front(); e != nil; e = e.Next() {
+		select {
+		case e.Value.(*waiter).moved <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// acquireResult Result of an Acquire run in the background.
+type acquireResult struct {
+	release func()
+	err     error
+}
+
+// acquireAsync Run Acquire in the background.
+func acquireAsync(ctx context.Context, l *Limiter, language string) <-chan acquireResult {
+	done := make(chan acquireResult, 1)
+	go func() {
+		release, err := l.Acquire(ctx, language)
+		done <- acquireResult{release: release, err: err}
+	}()
+	return done
+}
+
+// waitStats Wait until the limiter has the running and queued executions.
+func waitStats(t *testing.T, l *Limiter, running int, queued int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		r, q := l.Stats()
+		if r == running && q == queued {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %d running, %d queued, want %d running, %d queued", r, q, running, queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitResult Wait for the result of an Acquire run in the background.
+func waitResult(t *testing.T, done <-chan acquireResult) acquireResult {
+	t.Helper()
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(time.Second):
+		t.Fatal("Acquire did not return")
+		return acquireResult{}
+	}
+}
+
+// assertPending Check that an Acquire run in the background is still waiting.
+func assertPending(t *testing.T, done <-chan acquireResult) {
+	t.Helper()
+	select {
+	case result := <-done:
+		t.Fatalf("Acquire returned early, err = %v", result.err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestLimiterCaps(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    LimiterConfig
+		languages []string
+		want      []error
+	}{
+		{
+			name:      "unlimited",
+			config:    LimiterConfig{},
+			languages: []string{"python", "python", "go"},
+			want:      []error{nil, nil, nil},
+		},
+		{
+			name:      "global cap",
+			config:    LimiterConfig{MaxConcurrent: 2},
+			languages: []string{"python", "go", "javascript"},
+			want:      []error{nil, nil, ErrQueueFull},
+		},
+		{
+			name:      "language cap",
+			config:    LimiterConfig{Languages: map[string]int{"python": 1}},
+			languages: []string{"python", "python", "go"},
+			want:      []error{nil, ErrQueueFull, nil},
+		},
+		{
+			name:      "global and language caps",
+			config:    LimiterConfig{MaxConcurrent: 2, Languages: map[string]int{"python": 1}},
+			languages: []string{"python", "python", "go", "go"},
+			want:      []error{nil, ErrQueueFull, nil, ErrQueueFull},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.config)
+			running := 0
+			for i, language := range tt.languages {
+				release, err := l.Acquire(context.Background(), language)
+				if !errors.Is(err, tt.want[i]) {
+					t.Fatalf("Acquire #%d (%s) err = %v, want %v", i, language, err, tt.want[i])
+				}
+				if err == nil {
+					running++
+					defer release()
+				}
+			}
+			waitStats(t, l, running, 0)
+		})
+	}
+}
+
+func TestLimiterQueueFull(t *testing.T) {
+	l := NewLimiter(LimiterConfig{MaxConcurrent: 1, QueueSize: 1})
+	release, err := l.Acquire(context.Background(), "python")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued := acquireAsync(context.Background(), l, "python")
+	waitStats(t, l, 1, 1)
+
+	if _, err := l.Acquire(context.Background(), "go"); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Acquire err = %v, want %v", err, ErrQueueFull)
+	}
+
+	release()
+	result := waitResult(t, queued)
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	result.release()
+	waitStats(t, l, 0, 0)
+}
+
+func TestLimiterFIFO(t *testing.T) {
+	l := NewLimiter(LimiterConfig{MaxConcurrent: 1, QueueSize: 3})
+	release, err := l.Acquire(context.Background(), "python")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := acquireAsync(context.Background(), l, "go")
+	waitStats(t, l, 1, 1)
+	second := acquireAsync(context.Background(), l, "javascript")
+	waitStats(t, l, 1, 2)
+
+	// The freed slot goes to the head of the queue, and an execution arriving afterwards queues behind the others.
+	release()
+	firstResult := waitResult(t, first)
+	if firstResult.err != nil {
+		t.Fatal(firstResult.err)
+	}
+	third := acquireAsync(context.Background(), l, "python")
+	waitStats(t, l, 1, 2)
+	assertPending(t, second)
+	assertPending(t, third)
+
+	firstResult.release()
+	secondResult := waitResult(t, second)
+	if secondResult.err != nil {
+		t.Fatal(secondResult.err)
+	}
+	assertPending(t, third)
+
+	secondResult.release()
+	thirdResult := waitResult(t, third)
+	if thirdResult.err != nil {
+		t.Fatal(thirdResult.err)
+	}
+	thirdResult.release()
+	waitStats(t, l, 0, 0)
+}
+
+func TestLimiterFIFOLanguageCap(t *testing.T) {
+	l := NewLimiter(LimiterConfig{MaxConcurrent: 2, Languages: map[string]int{"python": 1}, QueueSize: 2})
+	release, err := l.Acquire(context.Background(), "python")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An execution blocked by its language limit lets the executions of other languages run.
+	python := acquireAsync(context.Background(), l, "python")
+	waitStats(t, l, 1, 1)
+	goRelease, err := l.Acquire(context.Background(), "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStats(t, l, 2, 1)
+
+	// Once the global limit is reached, the queued python execution gets the python slot before a later go execution.
+	goQueued := acquireAsync(context.Background(), l, "go")
+	waitStats(t, l, 2, 2)
+	release()
+	pythonResult := waitResult(t, python)
+	if pythonResult.err != nil {
+		t.Fatal(pythonResult.err)
+	}
+	assertPending(t, goQueued)
+
+	goRelease()
+	goResult := waitResult(t, goQueued)
+	if goResult.err != nil {
+		t.Fatal(goResult.err)
+	}
+	pythonResult.release()
+	goResult.release()
+	waitStats(t, l, 0, 0)
+}
+
+func TestLimiterAbandon(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		cancel  bool
+		want    error
+	}{
+		{name: "queue timeout", timeout: 20 * time.Millisecond, want: ErrQueueTimeout},
+		{name: "context canceled", cancel: true, want: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(LimiterConfig{MaxConcurrent: 1, QueueSize: 1, QueueTimeout: tt.timeout})
+			release, err := l.Acquire(context.Background(), "python")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			queued := acquireAsync(ctx, l, "python")
+			waitStats(t, l, 1, 1)
+			if tt.cancel {
+				cancel()
+			}
+
+			result := waitResult(t, queued)
+			if !errors.Is(result.err, tt.want) {
+				t.Fatalf("Acquire err = %v, want %v", result.err, tt.want)
+			}
+			// The waiter left the queue, so it neither blocks new executions nor gets the released slot.
+			waitStats(t, l, 1, 0)
+			next := acquireAsync(context.Background(), l, "python")
+			waitStats(t, l, 1, 1)
+
+			release()
+			nextResult := waitResult(t, next)
+			if nextResult.err != nil {
+				t.Fatal(nextResult.err)
+			}
+			nextResult.release()
+			waitStats(t, l, 0, 0)
+		})
+	}
+}
+
+// progressReport Progress reported by the limiter.
+type progressReport struct {
+	Progress float64
+	Total    float64
+	Message  string
+}
+
+// progressRecorder Record the reported progress.
+type progressRecorder struct {
+	mu      sync.Mutex
+	reports []progressReport
+}
+
+// context Return a context reporting the progress to the recorder.
+func (r *progressRecorder) context() context.Context {
+	return WithProgress(context.Background(), func(progress float64, total float64, message string) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.reports = append(r.reports, progressReport{Progress: progress, Total: total, Message: message})
+	})
+}
+
+// wait Wait until n reports are recorded and return them.
+func (r *progressRecorder) wait(t *testing.T, n int) []progressReport {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.mu.Lock()
+		reports := append([]progressReport{}, r.reports...)
+		r.mu.Unlock()
+		if len(reports) >= n {
+			return reports
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d progress reports %v, want %d", len(reports), reports, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterPositions(t *testing.T) {
+	l := NewLimiter(LimiterConfig{MaxConcurrent: 1, QueueSize: 3})
+	release, err := l.Acquire(context.Background(), "python")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := acquireAsync(context.Background(), l, "python")
+	waitStats(t, l, 1, 1)
+	abandoned, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	second := acquireAsync(abandoned, l, "python")
+	waitStats(t, l, 1, 2)
+
+	var recorder progressRecorder
+	third := acquireAsync(recorder.context(), l, "python")
+	waitStats(t, l, 1, 3)
+	recorder.wait(t, 1)
+
+	// The execution ahead leaving the queue and the head being admitted each advance the position.
+	cancel()
+	waitResult(t, second)
+	recorder.wait(t, 2)
+
+	release()
+	firstResult := waitResult(t, first)
+	if firstResult.err != nil {
+		t.Fatal(firstResult.err)
+	}
+	recorder.wait(t, 3)
+
+	firstResult.release()
+	thirdResult := waitResult(t, third)
+	if thirdResult.err != nil {
+		t.Fatal(thirdResult.err)
+	}
+	thirdResult.release()
+
+	want := []progressReport{
+		{Progress: 0, Total: 3, Message: "Queued at position 3"},
+		{Progress: 1, Total: 3, Message: "Queued at position 2"},
+		{Progress: 2, Total: 3, Message: "Queued at position 1"},
+		{Progress: 3, Total: 3, Message: "Execution slot acquired"},
+	}
+	if got := recorder.wait(t, len(want)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("progress = %v, want %v", got, want)
+	}
+}
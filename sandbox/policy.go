@@ -0,0 +1,68 @@
+package sandbox
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Policy Permissions of a client, empty lists and 0 limits mean unrestricted.
+type Policy struct {
+	Languages    []string       // allowed languages
+	Tools        []string       // enabled tools
+	Network      *bool          // whether the sandboxes have network access, the runtime setting if nil
+	MaxResources ResourceConfig // caps of the sandbox resources
+}
+
+// AllowsTool Whether the tool is enabled for the client.
+func (p *Policy) AllowsTool(tool string) bool {
+	return len(p.Tools) == 0 || slices.Contains(p.Tools, tool)
+}
+
+// Authorize Check the sandbox config against the policy, then cap its resources and set its network access.
+func (p *Policy) Authorize(config *Config) error {
+	if len(p.Languages) > 0 && !slices.Contains(p.Languages, config.Language) {
+		return fmt.Errorf("language %s is not allowed", config.Language)
+	}
+
+	// Installing dependencies reaches the network, which the policy denies.
+	if p.Network != nil && !*p.Network && len(config.Dependencies) > 0 {
+		return fmt.Errorf("dependencies are not allowed without network access")
+	}
+	if p.Network != nil {
+		config.NetWork = &NetWorkConfig{Enabled: *p.Network}
+	}
+	if config.Resource != nil {
+		p.Clamp(config.Resource)
+	}
+	p.clampPhases(config)
+	return nil
+}
+
+// Clamp Cap the resources to the max resources of the policy.
+func (p *Policy) Clamp(resource *ResourceConfig) {
+	if max := p.MaxResources.CpuTimeout; max > 0 && (resource.CpuTimeout <= 0 || resource.CpuTimeout > max) {
+		resource.CpuTimeout = max
+	}
+	if max := p.MaxResources.MemoryMb; max > 0 && (resource.MemoryMb <= 0 || resource.MemoryMb > max) {
+		resource.MemoryMb = max
+	}
+	if max := p.MaxResources.DiskMb; max > 0 && (resource.DiskMb <= 0 || resource.DiskMb > max) {
+		resource.DiskMb = max
+	}
+	if max := p.MaxResources.Cpus; max > 0 && (resource.Cpus <= 0 || resource.Cpus > max) {
+		resource.Cpus = max
+	}
+}
+
+// clampPhases Cap the timeouts of the phases to the max cpu timeout of the policy.
+func (p *Policy) clampPhases(config *Config) {
+	max := p.MaxResources.CpuTimeout
+	if max <= 0 {
+		return
+	}
+	for _, phase := range []*PhaseConfig{config.Install, config.Compile, config.Run} {
+		if phase != nil && (phase.Timeout <= 0 || phase.Timeout > max) {
+			phase.Timeout = max
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package sandbox
+
+import "context"
+
+// ProgressFunc Report the progress of a long running operation, total is 0 when unknown.
+type ProgressFunc func(progress float64, total float64, message string)
+
+type progressKey struct{}
+
+// WithProgress Return a context reporting progress to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// ReportProgress Report progress to the ProgressFunc of the context, if any.
+func ReportProgress(ctx context.Context, progress float64, total float64, message string) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok && fn != nil {
+		fn(progress, total, message)
+	}
+}
@@ -0,0 +1,187 @@
+package sandbox
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRateLimited The client started more executions than allowed by the rate limit.
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrQuotaExceeded The client used up its CPU or wall time quota of the window.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// QuotaConfig Per-client limits, 0 means unlimited.
+type QuotaConfig struct {
+	RateLimit    int           // max executions started per rate interval
+	RateInterval time.Duration // sliding interval of the rate limit, the rate limit is disabled if 0
+	Window       time.Duration // fixed window of the cumulative quotas, the quotas are disabled if 0
+	CpuTime      time.Duration // max CPU time per window
+	WallTime     time.Duration // max wall time per window
+}
+
+// QuotaStatus Usage of a client.
+type QuotaStatus struct {
+	Client       string
+	Executions   int           // executions started within the rate interval
+	RateLimit    int           // 0 means unlimited
+	RateInterval time.Duration // sliding interval of the rate limit
+	CpuTime      time.Duration // CPU time used in the window
+	CpuLimit     time.Duration // 0 means unlimited
+	WallTime     time.Duration // wall time used in the window
+	WallLimit    time.Duration // 0 means unlimited
+	ResetAt      time.Time     // end of the current window
+}
+
+// QuotaManager Enforce the rate limit and the cumulative quotas of every client.
+type QuotaManager struct {
+	config  QuotaConfig
+	mu      sync.Mutex
+	clients map[string]*clientUsage
+	now     func() time.Time // clock of the windows and the rate interval
+}
+
+// clientUsage Usage of a client in the current window.
+type clientUsage struct {
+	started     []time.Time // start times of the executions within the rate interval
+	windowStart time.Time
+	cpuTime     time.Duration
+	wallTime    time.Duration
+}
+
+// NewQuotaManager Create a quota manager.
+func NewQuotaManager(config QuotaConfig) *QuotaManager {
+	return &QuotaManager{
+		config:  config,
+		clients: make(map[string]*clientUsage),
+		now:     time.Now,
+	}
+}
+
+// SetConfig Apply new limits, the usage of the clients is kept.
+func (m *QuotaManager) SetConfig(config QuotaConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.config = config
+}
+
+// Allow Record the start of an execution of the client, ErrRateLimited or ErrQuotaExceeded
+// is returned with the status of the client if it is over its limits.
+// The returned func refunds the start and must be called if the execution is rejected afterwards,
+// e.g. by the concurrency limiter, so that it does not count against the rate limit.
+func (m *QuotaManager) Allow(client string) (QuotaStatus, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.expire(now)
+	usage := m.usage(client, now)
+
+	if m.config.Window > 0 && m.config.CpuTime > 0 && usage.cpuTime >= m.config.CpuTime {
+		return m.status(client, usage), nil, ErrQuotaExceeded
+	}
+	if m.config.Window > 0 && m.config.WallTime > 0 && usage.wallTime >= m.config.WallTime {
+		return m.status(client, usage), nil, ErrQuotaExceeded
+	}
+	if m.config.RateInterval > 0 && m.config.RateLimit > 0 && len(usage.started) >= m.config.RateLimit {
+		return m.status(client, usage), nil, ErrRateLimited
+	}
+
+	usage.started = append(usage.started, now)
+	return m.status(client, usage), m.refundFunc(client, now), nil
+}
+
+// refundFunc Return the func forgetting the execution of the client started at started, it is safe to call more than once.
+func (m *QuotaManager) refundFunc(client string, started time.Time) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			usage, ok := m.clients[client]
+			if !ok {
+				return
+			}
+			for i, t := range usage.started {
+				if t.Equal(started) {
+					usage.started = append(usage.started[:i], usage.started[i+1:]...)
+					return
+				}
+			}
+		})
+	}
+}
+
+// Charge Add the resources used by an execution of the client to its window.
+func (m *QuotaManager) Charge(client string, cpuTime time.Duration, wallTime time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage := m.usage(client, m.now())
+	usage.cpuTime += cpuTime
+	usage.wallTime += wallTime
+}
+
+// Status Return the usage of the client.
+func (m *QuotaManager) Status(client string) QuotaStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.status(client, m.usage(client, m.now()))
+}
+
+// usage Return the usage of the client, starting a new window if the current one is over.
+// The caller must hold mu.
+func (m *QuotaManager) usage(client string, now time.Time) *clientUsage {
+	usage, ok := m.clients[client]
+	if !ok {
+		usage = &clientUsage{windowStart: now}
+		m.clients[client] = usage
+	}
+	if m.config.Window > 0 && now.Sub(usage.windowStart) >= m.config.Window {
+		usage.windowStart = now
+		usage.cpuTime = 0
+		usage.wallTime = 0
+	}
+
+	kept := usage.started[:0]
+	for _, started := range usage.started {
+		if now.Sub(started) < m.config.RateInterval {
+			kept = append(kept, started)
+		}
+	}
+	usage.started = kept
+	return usage
+}
+
+// expire Forget the clients without executions in the rate interval and the window, the caller must hold mu.
+func (m *QuotaManager) expire(now time.Time) {
+	for client, usage := range m.clients {
+		idle := len(usage.started) == 0 || now.Sub(usage.started[len(usage.started)-1]) >= m.config.RateInterval
+		if idle && (m.config.Window <= 0 || now.Sub(usage.windowStart) >= m.config.Window) {
+			delete(m.clients, client)
+		}
+	}
+}
+
+// status Return the status of the client, the caller must hold mu.
+func (m *QuotaManager) status(client string, usage *clientUsage) QuotaStatus {
+	status := QuotaStatus{
+		Client:       client,
+		Executions:   len(usage.started),
+		RateLimit:    m.config.RateLimit,
+		RateInterval: m.config.RateInterval,
+		CpuTime:      usage.cpuTime,
+		CpuLimit:     m.config.CpuTime,
+		WallTime:     usage.wallTime,
+		WallLimit:    m.config.WallTime,
+	}
+	if m.config.Window > 0 {
+		status.ResetAt = usage.windowStart.Add(m.config.Window)
+	}
+	return status
+}
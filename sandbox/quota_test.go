@@ -0,0 +1,167 @@
+package sandbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestQuotaManager Create a quota manager whose clock is advanced by the returned func.
+func newTestQuotaManager(config QuotaConfig) (*QuotaManager, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewQuotaManager(config)
+	m.now = func() time.Time { return now }
+	return m, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestQuotaRateLimit(t *testing.T) {
+	m, advance := newTestQuotaManager(QuotaConfig{RateLimit: 2, RateInterval: time.Minute})
+
+	steps := []struct {
+		advance    time.Duration
+		want       error
+		executions int
+	}{
+		{advance: 0, want: nil, executions: 1},
+		{advance: 10 * time.Second, want: nil, executions: 2},
+		{advance: 10 * time.Second, want: ErrRateLimited, executions: 2},
+		// The first start leaves the sliding interval.
+		{advance: 40 * time.Second, want: nil, executions: 2},
+		{advance: 0, want: ErrRateLimited, executions: 2},
+		// Both remaining starts leave the sliding interval.
+		{advance: 2 * time.Minute, want: nil, executions: 1},
+	}
+	for i, step := range steps {
+		advance(step.advance)
+		status, _, err := m.Allow("client")
+		if !errors.Is(err, step.want) {
+			t.Fatalf("step %d: Allow err = %v, want %v", i, err, step.want)
+		}
+		if status.Executions != step.executions {
+			t.Fatalf("step %d: executions = %d, want %d", i, status.Executions, step.executions)
+		}
+	}
+
+	if status := m.Status("other"); status.Executions != 0 {
+		t.Fatalf("executions of another client = %d, want 0", status.Executions)
+	}
+}
+
+func TestQuotaRefund(t *testing.T) {
+	m, advance := newTestQuotaManager(QuotaConfig{RateLimit: 2, RateInterval: time.Minute})
+
+	_, refund, err := m.Allow("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Second)
+	if _, _, err := m.Allow("client"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Allow("client"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Allow err = %v, want %v", err, ErrRateLimited)
+	}
+
+	// A refund forgets only its own start, however many times it is called.
+	refund()
+	refund()
+	if status := m.Status("client"); status.Executions != 1 {
+		t.Fatalf("executions = %d, want 1", status.Executions)
+	}
+	if _, _, err := m.Allow("client"); err != nil {
+		t.Fatalf("Allow after refund err = %v", err)
+	}
+	if _, _, err := m.Allow("client"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Allow err = %v, want %v", err, ErrRateLimited)
+	}
+}
+
+func TestQuotaWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   QuotaConfig
+		cpuTime  time.Duration
+		wallTime time.Duration
+	}{
+		{
+			name:     "cpu time",
+			config:   QuotaConfig{Window: time.Hour, CpuTime: 10 * time.Second},
+			cpuTime:  5 * time.Second,
+			wallTime: time.Second,
+		},
+		{
+			name:     "wall time",
+			config:   QuotaConfig{Window: time.Hour, WallTime: 10 * time.Second},
+			cpuTime:  time.Second,
+			wallTime: 5 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, advance := newTestQuotaManager(tt.config)
+			start := m.now()
+
+			for i := 0; i < 2; i++ {
+				if _, _, err := m.Allow("client"); err != nil {
+					t.Fatalf("Allow #%d err = %v", i, err)
+				}
+				advance(10 * time.Minute)
+				m.Charge("client", tt.cpuTime, tt.wallTime)
+			}
+
+			status, _, err := m.Allow("client")
+			if !errors.Is(err, ErrQuotaExceeded) {
+				t.Fatalf("Allow err = %v, want %v", err, ErrQuotaExceeded)
+			}
+			if status.CpuTime != 2*tt.cpuTime || status.WallTime != 2*tt.wallTime {
+				t.Fatalf("usage = %s cpu, %s wall, want %s cpu, %s wall", status.CpuTime, status.WallTime, 2*tt.cpuTime, 2*tt.wallTime)
+			}
+			if want := start.Add(time.Hour); !status.ResetAt.Equal(want) {
+				t.Fatalf("reset at %s, want %s", status.ResetAt, want)
+			}
+
+			// The usage is reset once the window is over.
+			advance(40 * time.Minute)
+			status = m.Status("client")
+			if status.CpuTime != 0 || status.WallTime != 0 {
+				t.Fatalf("usage after the window = %s cpu, %s wall, want 0", status.CpuTime, status.WallTime)
+			}
+			if want := m.now().Add(time.Hour); !status.ResetAt.Equal(want) {
+				t.Fatalf("reset at %s, want %s", status.ResetAt, want)
+			}
+			if _, _, err := m.Allow("client"); err != nil {
+				t.Fatalf("Allow after the window err = %v", err)
+			}
+		})
+	}
+}
+
+func TestQuotaExpire(t *testing.T) {
+	m, advance := newTestQuotaManager(QuotaConfig{RateLimit: 1, RateInterval: time.Minute, Window: time.Hour, CpuTime: time.Minute})
+
+	if _, _, err := m.Allow("idle"); err != nil {
+		t.Fatal(err)
+	}
+	m.Charge("idle", 30*time.Second, 30*time.Second)
+
+	// The idle client is kept until both its rate interval and its window are over.
+	advance(30 * time.Minute)
+	if _, _, err := m.Allow("active"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.clients["idle"]; !ok {
+		t.Fatal("client forgotten within its window")
+	}
+
+	advance(30 * time.Minute)
+	if _, _, err := m.Allow("active"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.clients["idle"]; ok {
+		t.Fatal("idle client not forgotten after its window")
+	}
+	if status := m.Status("idle"); status.CpuTime != 0 || status.Executions != 0 {
+		t.Fatalf("status of the forgotten client = %+v, want no usage", status)
+	}
+}
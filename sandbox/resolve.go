@@ -0,0 +1,129 @@
+package sandbox
+
+import (
+	"fmt"
+	"time"
+)
+
+// Built-in resource defaults, used when no config layer sets a value.
+const (
+	DefaultCpuTimeout = 30 * time.Second
+	DefaultMemoryMb   = 256
+	DefaultDiskMb     = 512
+)
+
+// Config layers the effective resources are resolved from, from the lowest to the highest precedence.
+const (
+	LayerDefault  = "default"
+	LayerRuntimes = "runtimes"
+	LayerLanguage = "language"
+	LayerVersion  = "version"
+)
+
+// ResourceSources Layer each effective resource value comes from.
+type ResourceSources struct {
+	CpuTimeout string
+	MemoryMb   string
+	DiskMb     string
+	Cpus       string
+}
+
+// ResourceRequest Resources requested for an execution, 0 keeps the configured value.
+type ResourceRequest struct {
+	Timeout  time.Duration // timeout of the run phase
+	MemoryMb int64
+	Cpus     float64
+}
+
+// Validate Check the requested values are usable.
+func (r ResourceRequest) Validate() error {
+	if r.Timeout < 0 || r.MemoryMb < 0 || r.Cpus < 0 {
+		return fmt.Errorf("requested resources must not be negative")
+	}
+	if r.MemoryMb > 0 && r.MemoryMb < minMemoryMb {
+		return fmt.Errorf("memory_mb must be at least %d", minMemoryMb)
+	}
+	return nil
+}
+
+// resourceLayer Resources set by a config layer.
+type resourceLayer struct {
+	name      string
+	resources resourcesConfig
+}
+
+// ResolveResources Resolve the resources of the language version by layering the built-in defaults,
+// runtimes.resources, the language resources and the version override, each set value overriding the previous layers.
+// version is the default version of the language if empty.
+func (c *SandboxConfig) ResolveResources(language string, version string) (ResourceConfig, ResourceSources) {
+	resources := ResourceConfig{
+		CpuTimeout: DefaultCpuTimeout,
+		MemoryMb:   DefaultMemoryMb,
+		DiskMb:     DefaultDiskMb,
+	}
+	sources := ResourceSources{
+		CpuTimeout: LayerDefault,
+		MemoryMb:   LayerDefault,
+		DiskMb:     LayerDefault,
+		Cpus:       LayerDefault,
+	}
+
+	languageConfig := c.Languages[language]
+	if version == "" {
+		version = languageConfig.DefaultImage
+	}
+	layers := []resourceLayer{
+		{LayerRuntimes, c.Runtimes.Resources},
+		{LayerLanguage, languageConfig.Resources},
+	}
+	for _, override := range languageConfig.VersionOverrides {
+		if override.Version == version {
+			layers = append(layers, resourceLayer{LayerVersion, override.Resources})
+		}
+	}
+
+	for _, layer := range layers {
+		if layer.resources.CpuTimeout > 0 {
+			resources.CpuTimeout, sources.CpuTimeout = layer.resources.CpuTimeout, layer.name
+		}
+		if layer.resources.MemoryMb > 0 {
+			resources.MemoryMb, sources.MemoryMb = layer.resources.MemoryMb, layer.name
+		}
+		if layer.resources.DiskMb > 0 {
+			resources.DiskMb, sources.DiskMb = layer.resources.DiskMb, layer.name
+		}
+		if layer.resources.Cpus > 0 {
+			resources.Cpus, sources.Cpus = layer.resources.Cpus, layer.name
+		}
+	}
+	return resources, sources
+}
+
+// ApplyResourceRequest Override the run timeout, memory and CPUs of the sandbox config with the requested ones.
+// Each requested value is capped by runtimes.max_resources, or by the configured value if no maximum is set,
+// so without a maximum a request can only lower a limit.
+func (c *SandboxConfig) ApplyResourceRequest(config *Config, request ResourceRequest) {
+	max := c.Runtimes.MaxResources
+	if request.Timeout > 0 && config.Run != nil {
+		config.Run.Timeout = capResource(request.Timeout, max.CpuTimeout, config.Run.Timeout)
+		config.Resource.CpuTimeout = config.Run.Timeout
+	}
+	if request.MemoryMb > 0 {
+		config.Resource.MemoryMb = capResource(request.MemoryMb, max.MemoryMb, config.Resource.MemoryMb)
+	}
+	if request.Cpus > 0 {
+		config.Resource.Cpus = capResource(request.Cpus, max.Cpus, config.Resource.Cpus)
+	}
+}
+
+// capResource Cap the requested value to max, or to the configured value if max is 0.
+// A value is not capped if both are 0, i.e. unlimited.
+func capResource[T ~int64 | ~float64](value T, max T, configured T) T {
+	if max <= 0 {
+		max = configured
+	}
+	if max > 0 && value > max {
+		return max
+	}
+	return value
+}
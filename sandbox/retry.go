@@ -3,29 +3,97 @@ package sandbox
 import (
 	"context"
 	"fmt"
+	"math/rand/v2"
 	"time"
 )
 
 type RetryableFunc func(ctx context.Context) error
 
-// WithRetry Create a retry decorator
-func WithRetry(maxAttempts int, delay time.Duration) func(retryableFunc RetryableFunc) RetryableFunc {
-	return func(fn RetryableFunc) RetryableFunc {
-		return func(ctx context.Context) error {
-			var err error
-			for attempt := 1; attempt <= maxAttempts; attempt++ {
-				err = fn(ctx)
-				if err == nil {
-					return nil
-				}
-
-				select {
-				case <-time.After(delay):
-				case <-ctx.Done():
-					return ctx.Err()
-				}
-			}
-			return fmt.Errorf("after %d attempts, last error: %w", maxAttempts, err)
+// DefaultRetryPolicy Retry policy of the engine operations when none is configured.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  3,
+	InitialDelay: 200 * time.Millisecond,
+	MaxDelay:     2 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+	MaxElapsed:   10 * time.Second,
+}
+
+// RetryPolicy Retry with exponential backoff and jitter, retrying only the errors the classifier accepts.
+type RetryPolicy struct {
+	MaxAttempts  int                  // attempts including the first one, 1 if 0
+	InitialDelay time.Duration        // delay before the second attempt
+	MaxDelay     time.Duration        // cap of the delay, uncapped if 0
+	Multiplier   float64              // growth of the delay after each attempt, 1 (constant delay) if below 1
+	Jitter       float64              // fraction of the delay randomized, e.g. 0.2 waits between 80% and 120% of it
+	MaxElapsed   time.Duration        // no retry is scheduled past this time since the first attempt, unbounded if 0
+	Retryable    func(err error) bool // whether an error is transient, every error is retried if nil
+}
+
+// Do Run fn until it succeeds, fails with a permanent error, or the attempts or the elapsed time run out.
+func (p RetryPolicy) Do(ctx context.Context, fn RetryableFunc) error {
+	start := time.Now()
+	delay := p.InitialDelay
+	attempts := max(p.MaxAttempts, 1)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx)
+		if err == nil {
+			return nil
 		}
+		if p.Retryable != nil && !p.Retryable(err) {
+			return err
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("after %d attempts, last error: %w", attempt, err)
+		}
+
+		wait := p.jitter(delay)
+		if p.MaxElapsed > 0 && time.Since(start)+wait > p.MaxElapsed {
+			return fmt.Errorf("gave up retrying after %s and %d attempts, last error: %w", time.Since(start).Round(time.Millisecond), attempt, err)
+		}
+		InternalLogger.Warnf("Attempt %d failed, retrying in %s: %v", attempt, wait.Round(time.Millisecond), err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		delay = p.next(delay)
+	}
+}
+
+// Wrap Return fn retried with the policy.
+func (p RetryPolicy) Wrap(fn RetryableFunc) RetryableFunc {
+	return func(ctx context.Context) error {
+		return p.Do(ctx, fn)
 	}
 }
+
+// next Return the delay following delay.
+func (p RetryPolicy) next(delay time.Duration) time.Duration {
+	if p.Multiplier > 1 {
+		delay = time.Duration(float64(delay) * p.Multiplier)
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// jitter Randomize the delay by the jitter fraction.
+func (p RetryPolicy) jitter(delay time.Duration) time.Duration {
+	if p.Jitter <= 0 || delay <= 0 {
+		return delay
+	}
+	spread := float64(delay) * min(p.Jitter, 1)
+	return time.Duration(float64(delay) - spread + rand.Float64()*2*spread)
+}
+
+// WithRetry Create a retry decorator
+// retrying every error with a constant delay, see RetryPolicy for backoff and error classification.
+func WithRetry(maxAttempts int, delay time.Duration) func(retryableFunc RetryableFunc) RetryableFunc {
+	return RetryPolicy{MaxAttempts: maxAttempts, InitialDelay: delay}.Wrap
+}
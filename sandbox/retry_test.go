@@ -0,0 +1,144 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errPermanent = errors.New("permanent")
+)
+
+// retryTransient Retry only errTransient.
+func retryTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		errs      []error // errors of the successive attempts, nil once they run out
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "first attempt succeeds",
+			policy:    RetryPolicy{MaxAttempts: 3, Retryable: retryTransient},
+			wantCalls: 1,
+		},
+		{
+			name:      "transient errors are retried",
+			policy:    RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, Retryable: retryTransient},
+			errs:      []error{errTransient, errTransient},
+			wantCalls: 3,
+		},
+		{
+			name:      "permanent error is not retried",
+			policy:    RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, Retryable: retryTransient},
+			errs:      []error{errTransient, errPermanent},
+			wantCalls: 2,
+			wantErr:   errPermanent,
+		},
+		{
+			name:      "every error is retried without a classifier",
+			policy:    RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond},
+			errs:      []error{errPermanent},
+			wantCalls: 2,
+		},
+		{
+			name:      "attempts are capped",
+			policy:    RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, Multiplier: 2, Retryable: retryTransient},
+			errs:      []error{errTransient, errTransient, errTransient, errTransient},
+			wantCalls: 3,
+			wantErr:   errTransient,
+		},
+		{
+			name:      "zero attempts run once",
+			policy:    RetryPolicy{Retryable: retryTransient},
+			errs:      []error{errTransient},
+			wantCalls: 1,
+			wantErr:   errTransient,
+		},
+		{
+			name:      "no retry past the max elapsed time",
+			policy:    RetryPolicy{MaxAttempts: 3, InitialDelay: time.Hour, MaxElapsed: time.Minute, Retryable: retryTransient},
+			errs:      []error{errTransient},
+			wantCalls: 1,
+			wantErr:   errTransient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := tt.policy.Do(context.Background(), func(ctx context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("err = %v, want nil", err)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoCanceledDuringBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Hour, Retryable: retryTransient}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- policy.Do(ctx, func(ctx context.Context) error {
+			calls++
+			return errTransient
+		})
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want %v", err, context.Canceled)
+		}
+		if calls != 1 {
+			t.Fatalf("calls = %d, want 1", calls)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Do did not return once the context was canceled")
+	}
+}
+
+func TestRetryPolicyNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		delay  time.Duration
+		want   time.Duration
+	}{
+		{name: "constant", policy: RetryPolicy{}, delay: time.Second, want: time.Second},
+		{name: "exponential", policy: RetryPolicy{Multiplier: 2}, delay: time.Second, want: 2 * time.Second},
+		{name: "capped", policy: RetryPolicy{Multiplier: 2, MaxDelay: 1500 * time.Millisecond}, delay: time.Second, want: 1500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.next(tt.delay); got != tt.want {
+				t.Fatalf("next(%s) = %s, want %s", tt.delay, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,42 +2,143 @@ package sandbox
 
 import (
 	"context"
+	"fmt"
 	"time"
 )
 
 // Config sandbox config
 type Config struct {
-	Suffix     string // file suffix
-	Language   string // language
-	Version    string // language version
-	Image      string // container image
-	WorkDir    string // work dir
-	BaseImage  string // base image
-	Entrypoint []string
-	Timeout    time.Duration   // total timeout
-	Resource   *ResourceConfig // resource config
-	NetWork    *NetWorkConfig  // network config
+	Suffix              string          // file suffix
+	Language            string          // language
+	Version             string          // language version
+	Image               string          // container image
+	WorkDir             string          // work dir
+	BaseImage           string          // base image
+	Dependencies        []string        // packages installed before the code is compiled and run
+	Install             *PhaseConfig    // dependency install phase, the entrypoint is rendered with the packages
+	CacheVolumes        []VolumeConfig  // named volumes holding package caches shared across executions
+	Prebuilt            *PrebuiltConfig // packages baked into an image derived from the base image, nil to run the base image
+	PullPolicy          string          // pull policy of the image, PullIfNotPresent if empty
+	VersionPattern      string          // pattern of the allowed versions, DefaultVersionPattern if empty
+	AllowedRepositories []string        // allowlist of image repositories, every repository if empty
+	ImageDigest         string          // digest the image of the version is pinned to, not pinned if empty
+	Compile             *PhaseConfig    // compile phase, nil for interpreted languages
+	Run                 *PhaseConfig    // run phase
+	Timeout             time.Duration   // wall-clock deadline of the whole execution, from the image pull to the cleanup, none if 0
+	Resource            *ResourceConfig // resource config
+	NetWork             *NetWorkConfig  // network config
 }
 
 type NetWorkConfig struct {
 	Enabled bool
 }
 
+// Pull policies of the sandbox image
+const (
+	PullAlways       = "always"         // pull the image before every execution
+	PullIfNotPresent = "if-not-present" // pull the image only if it is missing locally
+	PullNever        = "never"          // never pull, the image must be present locally
+)
+
+// RegistryCredential credentials of an image registry
+type RegistryCredential struct {
+	Host          string // registry host, such as registry.example.com or docker.io
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+// VolumeConfig named volume mounted into the sandbox
+type VolumeConfig struct {
+	Name   string // volume name
+	Target string // mount path in the sandbox
+}
+
+// PrebuiltConfig packages baked into the derived image
+type PrebuiltConfig struct {
+	Packages []string // packages to bake in
+	Install  string   // RUN instruction template, rendered with the packages
+}
+
+// PhaseConfig command and timeout of an execution phase
+type PhaseConfig struct {
+	Entrypoint []string      // command template, the third element is rendered with the exec file and path
+	Timeout    time.Duration // phase timeout
+	Artifact   string        // path of the compiled artifact in the sandbox, cached across executions when set
+}
+
 // ResourceConfig sandbox env resource limit
 type ResourceConfig struct {
 	CpuTimeout time.Duration //
 	MemoryMb   int64
 	DiskMb     int64
+	Cpus       float64 // CPUs the container may use, unlimited if 0
 }
 
-// ExecutionResult execution result
-type ExecutionResult struct {
+// PhaseResult result of a single execution phase
+type PhaseResult struct {
 	Stdout   string        // standard output
 	Stderr   string        // standard error
 	ExitCode int           // exit code
+	TimedOut bool          // whether the phase was killed by its timeout
+	Cached   bool          // whether the compiled artifact was served from the cache
 	Duration time.Duration // duration
 }
 
+// Succeeded Whether the phase exited normally with code 0.
+func (r *PhaseResult) Succeeded() bool {
+	return !r.TimedOut && r.ExitCode == 0
+}
+
+// ExecutionResult execution result
+type ExecutionResult struct {
+	Install  *PhaseResult  // dependency install phase result, nil when no dependencies were requested
+	Compile  *PhaseResult  // compile phase result, nil when the language has no compile phase
+	Stdout   string        // standard output of the run phase
+	Stderr   string        // standard error of the run phase
+	ExitCode int           // exit code, the install or compile exit code if that phase failed
+	Duration time.Duration // duration
+	CpuTime  time.Duration // CPU time consumed by the container across all phases, 0 if unknown
+	Timings  []PhaseTiming // duration of each phase in the order they ran
+}
+
+// Execution phases
+const (
+	PhasePull    = "pull"
+	PhaseCreate  = "create"
+	PhaseStart   = "start"
+	PhaseInstall = "install"
+	PhaseCompile = "compile"
+	PhaseRun     = "run"
+	PhaseCleanup = "cleanup"
+)
+
+// PhaseTiming duration of an execution phase
+type PhaseTiming struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// DeadlineError the execution deadline expired during a phase
+type DeadlineError struct {
+	Phase   string        // phase running when the deadline expired
+	Timeout time.Duration // execution deadline
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("execution timed out after %s during the %s phase", e.Timeout, e.Phase)
+}
+
+// InstallFailed Whether the dependencies failed to install, in which case the compile and run phases are skipped.
+func (r *ExecutionResult) InstallFailed() bool {
+	return r.Install != nil && !r.Install.Succeeded()
+}
+
+// CompileFailed Whether the code failed to compile, in which case the run phase is skipped.
+func (r *ExecutionResult) CompileFailed() bool {
+	return r.Compile != nil && !r.Compile.Succeeded()
+}
+
 // Sandbox abstract interface
 type Sandbox interface {
 	// Execute Sandbox execution method
@@ -46,7 +147,7 @@ type Sandbox interface {
 	// code: execute code
 	//
 	// return:
-	// *ExecutionResult: execution result
+	// *ExecutionResult: execution result, also returned with a *DeadlineError holding the timings of the phases that ran
 	// error:
 	Execute(ctx context.Context, code string) (*ExecutionResult, error)
 
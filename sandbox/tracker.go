@@ -0,0 +1,114 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrDraining The server is shutting down and does not accept new executions.
+var ErrDraining = errors.New("server is shutting down")
+
+// Tracker Track the running executions, so the server can drain them and clean up the remaining sandboxes on shutdown.
+type Tracker struct {
+	mu       sync.Mutex
+	active   map[Sandbox]struct{} // sandboxes of the running executions, once created
+	running  int                  // acquired executions, including the queued ones and those still creating their sandbox
+	wg       sync.WaitGroup
+	draining bool
+}
+
+// NewTracker Create an execution tracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		active: make(map[Sandbox]struct{}),
+	}
+}
+
+// Acquire Register a new execution, ErrDraining is returned once the tracker is draining.
+// Every successful Acquire must be paired with a Release.
+func (t *Tracker) Acquire() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return ErrDraining
+	}
+	t.running++
+	t.wg.Add(1)
+	return nil
+}
+
+// Add Register the sandbox of an acquired execution for the cleanup on shutdown.
+func (t *Tracker) Add(sb Sandbox) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.active[sb] = struct{}{}
+}
+
+// Release Mark the execution as finished, sb may be nil if the sandbox was never created.
+func (t *Tracker) Release(sb Sandbox) {
+	t.mu.Lock()
+	if sb != nil {
+		delete(t.active, sb)
+	}
+	t.running--
+	t.mu.Unlock()
+
+	t.wg.Done()
+}
+
+// Active Return the number of running executions, from Acquire to Release.
+func (t *Tracker) Active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.running
+}
+
+// Draining Whether the tracker stopped accepting new executions.
+func (t *Tracker) Draining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.draining
+}
+
+// Drain Stop accepting new executions and wait for the running ones until ctx is done.
+func (t *Tracker) Drain(ctx context.Context) error {
+	t.mu.Lock()
+	t.draining = true
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cleanup Force the cleanup of the sandboxes still running.
+func (t *Tracker) Cleanup(ctx context.Context) error {
+	t.mu.Lock()
+	sandboxes := make([]Sandbox, 0, len(t.active))
+	for sb := range t.active {
+		sandboxes = append(sandboxes, sb)
+	}
+	t.mu.Unlock()
+
+	var errs []error
+	for _, sb := range sandboxes {
+		if err := sb.Cleanup(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,311 @@
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// entrypointLength Entrypoints are a shell and its flag followed by the templated command, e.g. ["sh", "-c", "..."].
+	entrypointLength = 3
+	// minMemoryMb Smallest memory limit accepted by Docker.
+	minMemoryMb = 6
+	// ReapMargin Least time a container outlives the execution deadline before it may be reaped, covering its cleanup.
+	ReapMargin = time.Minute
+)
+
+// Client keys of the quotas.
+var quotaClientKeys = []string{"", "api_key", "session", "ip"}
+
+// pullPolicies Valid pull policies, empty means if-not-present.
+var pullPolicies = []string{"", PullAlways, PullIfNotPresent, PullNever}
+
+// imageTmplData Data the base_image template is rendered with, the same fields as the engine image template.
+type imageTmplData struct {
+	Version  string
+	Language string
+}
+
+// entrypointTmplData Data the entrypoint templates are rendered with, the same fields as the engine entrypoint template.
+type entrypointTmplData struct {
+	ExecFile string
+	Path     string
+	Packages string
+}
+
+// Validate Check the config is usable, all the problems are returned joined.
+func (c *SandboxConfig) Validate() error {
+	v := &validator{}
+	c.validateServer(v)
+	c.validateRuntimes(v)
+
+	if len(c.Languages) == 0 {
+		v.addf("languages", "no language configured")
+	}
+	languages := make([]string, 0, len(c.Languages))
+	for language := range c.Languages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		c.validateLanguage(v, language)
+	}
+	return errors.Join(v.errs...)
+}
+
+// validator Collect the problems of the config, each prefixed with the key it is about.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) addf(key string, format string, args ...interface{}) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+// nonNegative Report a negative number or duration.
+func (v *validator) nonNegative(key string, value int64) {
+	if value < 0 {
+		v.addf(key, "must not be negative")
+	}
+}
+
+// oneOf Report a value outside of the allowed ones.
+func (v *validator) oneOf(key string, value string, allowed []string) {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return
+		}
+	}
+	v.addf(key, "invalid value %q, expected one of %s", value, strings.Join(allowed[1:], ", "))
+}
+
+// resources Check the resource bounds.
+func (v *validator) resources(key string, resources resourcesConfig) {
+	v.nonNegative(key+".cpu_timeout", int64(resources.CpuTimeout))
+	v.nonNegative(key+".disk_mb", resources.DiskMb)
+	if resources.Cpus < 0 {
+		v.addf(key+".cpus", "must not be negative")
+	}
+	if resources.MemoryMb < 0 || (resources.MemoryMb > 0 && resources.MemoryMb < minMemoryMb) {
+		v.addf(key+".memory_mb", "must be 0 or at least %d", minMemoryMb)
+	}
+}
+
+// entrypoint Check the entrypoint has the expected length and its command is a valid template.
+func (v *validator) entrypoint(key string, entrypoint []string) {
+	if len(entrypoint) != entrypointLength {
+		v.addf(key, "must have %d elements, e.g. [\"sh\", \"-c\", \"<command>\"], got %d", entrypointLength, len(entrypoint))
+		return
+	}
+	v.template(key, entrypoint[2], entrypointTmplData{ExecFile: "/tmp/main", Path: "/tmp", Packages: "'package'"})
+}
+
+// template Check the template parses and renders with sample data.
+func (v *validator) template(key string, text string, data interface{}) string {
+	tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+	if err != nil {
+		v.addf(key, "invalid template: %v", err)
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		v.addf(key, "invalid template: %v", err)
+		return ""
+	}
+	return buf.String()
+}
+
+// validateServer Check the server section.
+func (c *SandboxConfig) validateServer(v *validator) {
+	server := c.Server
+	if server.SSEEndpoint != "" && !strings.HasPrefix(server.SSEEndpoint, "/") {
+		v.addf("server.sse_endpoint", "must start with /")
+	}
+	if server.MessageEndpoint != "" && !strings.HasPrefix(server.MessageEndpoint, "/") {
+		v.addf("server.message_endpoint", "must start with /")
+	}
+	if (server.TLS.CertFile == "") != (server.TLS.KeyFile == "") {
+		v.addf("server.tls", "cert_file and key_file must be set together")
+	}
+	if server.TLS.ClientCAFile != "" && server.TLS.CertFile == "" {
+		v.addf("server.tls.client_ca_file", "requires cert_file and key_file")
+	}
+
+	if server.Auth.Enabled && len(server.Auth.Keys) == 0 && server.Auth.KeysFile == "" {
+		v.addf("server.auth", "enabled without keys or keys_file")
+	}
+	for i, key := range server.Auth.Keys {
+		if key.Client == "" || key.Key == "" {
+			v.addf(fmt.Sprintf("server.auth.keys[%d]", i), "client and key are required")
+		}
+	}
+	for i, policy := range append([]policyConfig{server.Auth.DefaultPolicy}, server.Auth.Policies...) {
+		key := "server.auth.default_policy"
+		if i > 0 {
+			key = fmt.Sprintf("server.auth.policies[%d]", i-1)
+			if len(policy.Clients) == 0 {
+				v.addf(key+".clients", "required")
+			}
+		}
+		for _, language := range policy.Languages {
+			if _, ok := c.Languages[language]; !ok {
+				v.addf(key+".languages", "unknown language %q", language)
+			}
+		}
+		v.resources(key+".max_resources", policy.MaxResources)
+	}
+}
+
+// validateRuntimes Check the runtimes section.
+func (c *SandboxConfig) validateRuntimes(v *validator) {
+	runtimes := c.Runtimes
+	v.resources("runtimes.resources", runtimes.Resources)
+	v.resources("runtimes.max_resources", runtimes.MaxResources)
+	v.nonNegative("runtimes.timeout", runtimes.Timeout)
+	v.nonNegative("runtimes.drain_timeout", int64(runtimes.DrainTimeout))
+	v.nonNegative("runtimes.reaper.interval", int64(runtimes.Reaper.Interval))
+	v.nonNegative("runtimes.reaper.ttl", int64(runtimes.Reaper.TTL))
+	// The reaper must not remove the containers of running executions.
+	if ttl := runtimes.Reaper.TTL; ttl > 0 {
+		deadline := time.Duration(runtimes.Timeout) * time.Second
+		switch {
+		case deadline <= 0:
+			v.addf("runtimes.reaper.ttl", "requires runtimes.timeout, executions without a deadline may outlive the ttl")
+		case ttl <= deadline+ReapMargin:
+			v.addf("runtimes.reaper.ttl", "must exceed runtimes.timeout (%s) plus %s for the cleanup", deadline, ReapMargin)
+		}
+	}
+	v.nonNegative("runtimes.health.interval", int64(runtimes.Health.Interval))
+	v.nonNegative("runtimes.health.failure_threshold", int64(runtimes.Health.FailureThreshold))
+	v.nonNegative("runtimes.health.open_timeout", int64(runtimes.Health.OpenTimeout))
+	v.nonNegative("runtimes.retry.max_attempts", int64(runtimes.Retry.MaxAttempts))
+	v.nonNegative("runtimes.retry.initial_delay", int64(runtimes.Retry.InitialDelay))
+	v.nonNegative("runtimes.retry.max_delay", int64(runtimes.Retry.MaxDelay))
+	v.nonNegative("runtimes.retry.max_elapsed", int64(runtimes.Retry.MaxElapsed))
+	if runtimes.Retry.Multiplier < 0 {
+		v.addf("runtimes.retry.multiplier", "must not be negative")
+	}
+	if runtimes.Retry.Jitter < 0 || runtimes.Retry.Jitter > 1 {
+		v.addf("runtimes.retry.jitter", "must be between 0 and 1")
+	}
+	v.nonNegative("runtimes.compile_cache.max_size_mb", runtimes.CompileCache.MaxSizeMb)
+	v.nonNegative("runtimes.compile_cache.max_entries", int64(runtimes.CompileCache.MaxEntries))
+
+	v.nonNegative("runtimes.concurrency.max_concurrent", int64(runtimes.Concurrency.MaxConcurrent))
+	v.nonNegative("runtimes.concurrency.queue_size", int64(runtimes.Concurrency.QueueSize))
+	v.nonNegative("runtimes.concurrency.queue_timeout", int64(runtimes.Concurrency.QueueTimeout))
+	limited := make([]string, 0, len(runtimes.Concurrency.Languages))
+	for language := range runtimes.Concurrency.Languages {
+		limited = append(limited, language)
+	}
+	sort.Strings(limited)
+	for _, language := range limited {
+		if _, ok := c.Languages[language]; !ok {
+			v.addf("runtimes.concurrency.languages", "unknown language %q", language)
+		}
+		v.nonNegative("runtimes.concurrency.languages."+language, int64(runtimes.Concurrency.Languages[language]))
+	}
+
+	quota := runtimes.Quota
+	v.oneOf("runtimes.quota.client_key", quota.ClientKey, quotaClientKeys)
+	v.nonNegative("runtimes.quota.rate_limit", int64(quota.RateLimit))
+	v.nonNegative("runtimes.quota.rate_interval", int64(quota.RateInterval))
+	v.nonNegative("runtimes.quota.window", int64(quota.Window))
+	v.nonNegative("runtimes.quota.cpu_time", int64(quota.CpuTime))
+	v.nonNegative("runtimes.quota.wall_time", int64(quota.WallTime))
+
+	for i, credential := range runtimes.Registry.Credentials {
+		if credential.Host == "" {
+			v.addf(fmt.Sprintf("runtimes.registry.credentials[%d].host", i), "required")
+		}
+	}
+}
+
+// validateLanguage Check a language section.
+func (c *SandboxConfig) validateLanguage(v *validator, language string) {
+	key := "languages." + language
+	languageConfig := c.Languages[language]
+
+	if languageConfig.Suffix == "" {
+		v.addf(key+".suffix", "required")
+	}
+	if languageConfig.DefaultImage == "" {
+		v.addf(key+".default_image", "required")
+	}
+	if languageConfig.BaseImage == "" {
+		v.addf(key+".base_image", "required")
+	} else if image := v.template(key+".base_image", languageConfig.BaseImage, imageTmplData{Version: languageConfig.DefaultImage, Language: language}); image != "" {
+		if err := ValidateImage(image, c.Runtimes.Images.AllowedRepositories); err != nil {
+			v.addf(key+".base_image", "%v", err)
+		}
+	}
+
+	if languageConfig.VersionPattern != "" {
+		if _, err := regexp.Compile(languageConfig.VersionPattern); err != nil {
+			v.addf(key+".version_pattern", "invalid pattern: %v", err)
+		}
+	}
+	for _, version := range append([]string{languageConfig.DefaultImage}, languageConfig.Versions...) {
+		if version == "" {
+			continue
+		}
+		if err := ValidateVersion(version, languageConfig.VersionPattern); err != nil {
+			v.addf(key+".versions", "%v", err)
+		}
+	}
+	for i, digest := range languageConfig.Digests {
+		if !digestPattern.MatchString(digest.Digest) {
+			v.addf(fmt.Sprintf("%s.digests[%d].digest", key, i), "invalid digest %q, expected sha256:<64 hex digits>", digest.Digest)
+		}
+	}
+	v.oneOf(key+".pull_policy", languageConfig.PullPolicy, pullPolicies)
+
+	// The run phase falls back to the single entrypoint.
+	switch {
+	case languageConfig.Run != nil:
+		v.entrypoint(key+".run.entrypoint", languageConfig.Run.Entrypoint)
+		v.nonNegative(key+".run.timeout", int64(languageConfig.Run.Timeout))
+	case len(languageConfig.Entrypoint) > 0:
+		v.entrypoint(key+".entrypoint", languageConfig.Entrypoint)
+	default:
+		v.addf(key, "entrypoint or run is required")
+	}
+	if languageConfig.Compile != nil {
+		v.entrypoint(key+".compile.entrypoint", languageConfig.Compile.Entrypoint)
+		v.nonNegative(key+".compile.timeout", int64(languageConfig.Compile.Timeout))
+	}
+	if languageConfig.Dependencies != nil {
+		v.oneOf(key+".dependencies.manager", languageConfig.Dependencies.Manager, append([]string{""}, Managers...))
+		if languageConfig.Dependencies.Manager == "" {
+			v.addf(key+".dependencies.manager", "required")
+		}
+		v.entrypoint(key+".dependencies.install", languageConfig.Dependencies.Install)
+		v.nonNegative(key+".dependencies.timeout", int64(languageConfig.Dependencies.Timeout))
+	}
+	if languageConfig.Prebuilt != nil && len(languageConfig.Prebuilt.Packages) > 0 {
+		if languageConfig.Prebuilt.Install == "" {
+			v.addf(key+".prebuilt.install", "required with packages")
+		} else {
+			v.template(key+".prebuilt.install", languageConfig.Prebuilt.Install, struct{ Packages string }{Packages: "'package'"})
+		}
+	}
+	for i, volume := range languageConfig.CacheVolumes {
+		if volume.Name == "" || !strings.HasPrefix(volume.Target, "/") {
+			v.addf(fmt.Sprintf("%s.cache_volumes[%d]", key, i), "name and an absolute target are required")
+		}
+	}
+	v.resources(key+".resources", languageConfig.Resources)
+	for i, override := range languageConfig.VersionOverrides {
+		overrideKey := fmt.Sprintf("%s.version_overrides[%d]", key, i)
+		if override.Version == "" {
+			v.addf(overrideKey+".version", "required")
+		}
+		v.resources(overrideKey+".resources", override.Resources)
+	}
+}